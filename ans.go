@@ -0,0 +1,454 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/bits"
+)
+
+const (
+	ANS_MIN_LOG    = 8
+	ANS_MAX_LOG    = 16
+	ANS_MAX_STATES = 32
+	/* the lower bound of a rANS state, which is renormalized a byte at a time */
+	RANS_L = 1 << 23
+)
+
+// ANS configures the rANS and tANS coders. The symbols are counted in blocks
+// of Block symbols, or over the whole input when Block is zero, and each
+// block is coded with its counts normalized to a total of 1<<Log, which is
+// raised as needed to give every symbol of the block a count. States
+// interleaved coder states take turns coding the symbols.
+type ANS struct {
+	Block, Log, States int
+}
+
+type ansCoder struct {
+	coder Coder16
+	ANS
+	table bool
+}
+
+type ansDecoder struct {
+	decoder Coder16
+	ANS
+	table bool
+}
+
+// RANSCoder codes the symbols with range asymmetric numeral systems: static
+// or block adaptive counts instead of a model, coded in reverse
+func (coder Coder16) RANSCoder(ans ANS) Encoder {
+	checkANS(ans)
+	return ansCoder{coder: coder, ANS: ans}
+}
+
+func (decoder Coder16) RANSDecoder(ans ANS) Decoder {
+	checkANS(ans)
+	return ansDecoder{decoder: decoder, ANS: ans}
+}
+
+// TANSCoder codes the symbols with tabled asymmetric numeral systems, the
+// finite state entropy coder, using the counts as RANSCoder does
+func (coder Coder16) TANSCoder(ans ANS) Encoder {
+	checkANS(ans)
+	return ansCoder{coder: coder, ANS: ans, table: true}
+}
+
+func (decoder Coder16) TANSDecoder(ans ANS) Decoder {
+	checkANS(ans)
+	return ansDecoder{decoder: decoder, ANS: ans, table: true}
+}
+
+func checkANS(ans ANS) {
+	if ans.Block < 0 || ans.Log < ANS_MIN_LOG || ans.Log > ANS_MAX_LOG || ans.States < 1 || ans.States > ANS_MAX_STATES {
+		panic("compress: invalid ANS configuration")
+	}
+}
+
+// Code writes each block as its length, the log of its total, its counts
+// and its coded bytes; a block of length zero ends the output
+func (a ansCoder) Code(out io.Writer) int {
+	count, block := 0, []uint16(nil)
+	flush := func() {
+		if len(block) == 0 {
+			return
+		}
+		counts, log := normalize(block, a.Log)
+		var payload []byte
+		if a.table {
+			payload = tansEncode(block, counts, log, a.States)
+		} else {
+			payload = ransEncode(block, counts, log, a.States)
+		}
+
+		header := make([]byte, 0, 64)
+		header = appendUvarint(header, uint64(len(block)))
+		header = append(header, byte(log))
+		header = appendCounts(header, counts)
+		header = appendUvarint(header, uint64(len(payload)))
+		out.Write(header)
+		out.Write(payload)
+		count += 8 * (len(header) + len(payload))
+		block = block[:0]
+	}
+
+	for input := range a.coder.Input {
+		for len(input) > 0 {
+			n := len(input)
+			if a.Block > 0 && len(block)+n > a.Block {
+				n = a.Block - len(block)
+			}
+			block, input = append(block, input[:n]...), input[n:]
+			if len(block) == a.Block {
+				flush()
+			}
+		}
+	}
+	flush()
+	out.Write([]byte{0})
+	return count + 8
+}
+
+// Decode decodes symbols from in until the output is full or the last block
+// is decoded. ErrTruncated is returned if in runs out of bytes before that.
+func (a ansDecoder) Decode(in io.Reader) (err error) {
+	defer recoverDecode(&err)
+
+	reader, ok := in.(io.ByteReader)
+	if !ok {
+		reader = &byteReader{Reader: in}
+	}
+	uvarint := func() uint64 {
+		value, err := binary.ReadUvarint(reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			raise(ErrTruncated)
+		} else if err != nil {
+			corrupt("ans decoder", 0)
+		}
+		return value
+	}
+
+	cancel := done(a.decoder.Context)
+	for {
+		select {
+		case <-cancel:
+			raise(a.decoder.Context.Err())
+		default:
+		}
+
+		n := uvarint()
+		if n == 0 {
+			return nil
+		}
+		log, err := reader.ReadByte()
+		if err != nil {
+			raise(ErrTruncated)
+		}
+		if log < ANS_MIN_LOG || log > ANS_MAX_LOG {
+			corrupt("ans decoder", uint32(log))
+		}
+		counts := readCounts(uvarint, int(a.decoder.Alphabit), uint(log))
+		length := uvarint()
+		payload, err := ioutil.ReadAll(io.LimitReader(in, int64(length)))
+		if err != nil {
+			raise(err)
+		} else if uint64(len(payload)) != length {
+			raise(ErrTruncated)
+		}
+
+		var finished bool
+		if a.table {
+			finished = tansDecode(payload, n, counts, uint(log), a.States, a.decoder.Output)
+		} else {
+			finished = ransDecode(payload, n, counts, uint(log), a.States, a.decoder.Output)
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+func appendUvarint(buffer []byte, value uint64) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	return append(buffer, varint[:binary.PutUvarint(varint[:], value)]...)
+}
+
+// normalize counts the symbols of block and scales the counts to a total of
+// 1<<log, keeping a count of at least one for every symbol that occurs
+func normalize(block []uint16, log int) ([]uint32, uint) {
+	size := 0
+	for _, s := range block {
+		if int(s) >= size {
+			size = int(s) + 1
+		}
+	}
+	counts, used := make([]uint32, size), 0
+	for _, s := range block {
+		if counts[s] == 0 {
+			used++
+		}
+		counts[s]++
+	}
+	for used > 1<<uint(log) {
+		log++
+	}
+
+	total, sum, largest, most := uint64(len(block)), uint32(0), 0, uint32(0)
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		if count > most {
+			largest, most = i, count
+		}
+		scaled := uint32((uint64(count) << uint(log)) / total)
+		if scaled == 0 {
+			scaled = 1
+		}
+		counts[i], sum = scaled, sum+scaled
+	}
+
+	/* the rounding error goes to the most frequent symbol, or is spread over
+	   every symbol that can spare a count */
+	target := uint32(1) << uint(log)
+	if sum < target {
+		counts[largest] += target - sum
+	}
+	for sum > target {
+		if counts[largest] > 1 && sum-target < counts[largest] {
+			counts[largest] -= sum - target
+			break
+		}
+		for i, count := range counts {
+			if count > 1 && sum > target {
+				counts[i], sum = count-1, sum-1
+			}
+		}
+	}
+	return counts, uint(log)
+}
+
+// appendCounts appends the number of counts and then each count, with a
+// run of zero counts coded as a zero and the length of the rest of the run
+func appendCounts(buffer []byte, counts []uint32) []byte {
+	buffer = appendUvarint(buffer, uint64(len(counts)))
+	for i := 0; i < len(counts); i++ {
+		buffer = appendUvarint(buffer, uint64(counts[i]))
+		if counts[i] == 0 {
+			run := 0
+			for i+1 < len(counts) && counts[i+1] == 0 {
+				i, run = i+1, run+1
+			}
+			buffer = appendUvarint(buffer, uint64(run))
+		}
+	}
+	return buffer
+}
+
+func readCounts(uvarint func() uint64, alphabit int, log uint) []uint32 {
+	size := uvarint()
+	if size == 0 || (alphabit > 0 && size > uint64(alphabit)) || size > 1<<16 {
+		corrupt("ans decoder", uint32(size))
+	}
+	counts, sum := make([]uint32, size), uint64(0)
+	for i := 0; i < len(counts); i++ {
+		count := uvarint()
+		if count > 1<<log {
+			corrupt("ans decoder", uint32(i))
+		}
+		counts[i], sum = uint32(count), sum+count
+		if count == 0 {
+			run := uvarint()
+			if run >= uint64(len(counts)-i) {
+				corrupt("ans decoder", uint32(i))
+			}
+			i += int(run)
+		}
+	}
+	if sum != 1<<log {
+		corrupt("ans decoder", uint32(sum))
+	}
+	return counts
+}
+
+// cumulative returns the start of the interval of each symbol and the symbol of each slot
+func cumulative(counts []uint32, log uint) ([]uint32, []uint16) {
+	starts, slots, start := make([]uint32, len(counts)), make([]uint16, 1<<log), uint32(0)
+	for s, count := range counts {
+		starts[s] = start
+		for i := start; i < start+count; i++ {
+			slots[i] = uint16(s)
+		}
+		start += count
+	}
+	return starts, slots
+}
+
+// ransEncode codes block in reverse into a stack of bytes, symbol i with
+// state i%states, and finally pushes the states
+func ransEncode(block []uint16, counts []uint32, log uint, states int) []byte {
+	starts, _ := cumulative(counts, log)
+	stack, x := make([]byte, 0, len(block)/2+4*states), make([]uint32, states)
+	for i := range x {
+		x[i] = RANS_L
+	}
+	for i := len(block) - 1; i >= 0; i-- {
+		s, state := block[i], &x[i%states]
+		count := counts[s]
+		for max := ((RANS_L >> log) << 8) * count; *state >= max; *state >>= 8 {
+			stack = append(stack, byte(*state))
+		}
+		*state = (*state/count)<<log + *state%count + starts[s]
+	}
+	for i := states - 1; i >= 0; i-- {
+		stack = append(stack, byte(x[i]), byte(x[i]>>8), byte(x[i]>>16), byte(x[i]>>24))
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return stack
+}
+
+// ransDecode decodes n symbols from payload into output, returning true if output is done
+func ransDecode(payload []byte, n uint64, counts []uint32, log uint, states int, output func(uint16) bool) bool {
+	starts, slots := cumulative(counts, log)
+	if len(payload) < 4*states {
+		corrupt("rans decoder", uint32(len(payload)))
+	}
+	x, i := make([]uint32, states), 0
+	for j := range x {
+		x[j], i = binary.BigEndian.Uint32(payload[i:]), i+4
+	}
+	mask := uint32(1)<<log - 1
+	for j := uint64(0); j < n; j++ {
+		state := &x[j%uint64(states)]
+		s := slots[*state&mask]
+		if output(s) {
+			return true
+		}
+		*state = counts[s]*(*state>>log) + *state&mask - starts[s]
+		for *state < RANS_L {
+			if i == len(payload) {
+				corrupt("rans decoder", uint32(j))
+			}
+			*state, i = *state<<8|uint32(payload[i]), i+1
+		}
+	}
+	return false
+}
+
+// spread deals the slots of the tANS table out to the symbols, in proportion
+// to their counts and scattered over the table as finite state entropy does
+func spread(counts []uint32, log uint) []uint16 {
+	size := uint32(1) << log
+	table, step, position := make([]uint16, size), size>>1+size>>3+3, uint32(0)
+	for s, count := range counts {
+		for i := uint32(0); i < count; i++ {
+			table[position] = uint16(s)
+			position = (position + step) & (size - 1)
+		}
+	}
+	return table
+}
+
+// tansEncode codes block in reverse, writing the bits shifted out of the
+// states, then the states and a closing one bit, so that the decoder reads the
+// bits from the end
+func tansEncode(block []uint16, counts []uint32, log uint, states int) []byte {
+	size := uint32(1) << log
+	starts, _ := cumulative(counts, log)
+	next, encode := make([]uint32, len(counts)), make([]uint32, size)
+	for u, s := range spread(counts, log) {
+		encode[starts[s]+next[s]] = size + uint32(u)
+		next[s]++
+	}
+
+	buffer := &bytes.Buffer{}
+	buffer.Grow(len(block)/2 + 8)
+	var bits uint64
+	var count uint
+	write := func(value uint32, n uint) {
+		bits, count = bits|uint64(value)<<count, count+n
+		for count >= 8 {
+			buffer.WriteByte(byte(bits))
+			bits, count = bits>>8, count-8
+		}
+	}
+
+	x := make([]uint32, states)
+	for i := range x {
+		x[i] = size
+	}
+	for i := len(block) - 1; i >= 0; i-- {
+		s, state := block[i], &x[i%states]
+		shift := shifts(*state, counts[s])
+		write(*state&(1<<shift-1), shift)
+		*state = encode[starts[s]+*state>>shift-counts[s]]
+	}
+	for i := states - 1; i >= 0; i-- {
+		write(x[i]-size, log)
+	}
+	write(1, 8-count%8)
+	return buffer.Bytes()
+}
+
+// shifts returns how many bits to shift out of state so that it falls in [count, 2*count)
+func shifts(state, count uint32) uint {
+	shift := uint(bits.Len32(state) - bits.Len32(count))
+	if state>>shift < count {
+		shift--
+	}
+	return shift
+}
+
+// tansDecode decodes n symbols from payload into output, returning true if output is done
+func tansDecode(payload []byte, n uint64, counts []uint32, log uint, states int, output func(uint16) bool) bool {
+	type entry struct {
+		symbol uint16
+		shift  uint8
+		base   uint32
+	}
+	size := uint32(1) << log
+	table, next := make([]entry, size), append([]uint32(nil), counts...)
+	for u, s := range spread(counts, log) {
+		x := next[s]
+		next[s]++
+		shift := log - uint(bits.Len32(x)-1)
+		table[u] = entry{symbol: s, shift: uint8(shift), base: x<<shift - size}
+	}
+
+	if len(payload) == 0 || payload[len(payload)-1] == 0 {
+		corrupt("tans decoder", 0)
+	}
+	position := 8*len(payload) - 8 + bits.Len8(payload[len(payload)-1]) - 1
+	read := func(n uint) uint32 {
+		if position -= int(n); position < 0 {
+			corrupt("tans decoder", uint32(-position))
+		}
+		var value uint32
+		for i, b := position>>3, uint(0); b < n+uint(position&7); i, b = i+1, b+8 {
+			value |= uint32(payload[i]) << b
+		}
+		return value >> uint(position&7) & (1<<n - 1)
+	}
+
+	x := make([]uint32, states)
+	for i := range x {
+		x[i] = read(log)
+	}
+	for j := uint64(0); j < n; j++ {
+		state := &x[j%uint64(states)]
+		e := table[*state]
+		if output(e.symbol) {
+			return true
+		}
+		*state = e.base + read(uint(e.shift))
+	}
+	return false
+}
@@ -155,24 +155,46 @@ func (model Model) CodeBit(out io.Writer) int {
 	return count
 }
 
-func (model Model) Decode(in io.Reader) {
+// Decode decodes symbols from in until the model signals the end of output.
+// ErrTruncated is returned if in runs out of bits before that.
+func (model Model) Decode(in io.Reader) (err error) {
+	defer recoverDecode(&err)
+
 	var bits [1]byte
 	var mask uint8 = 0x80
 	var code uint16
+	var eof, past uint32
+
+	cancel := done(model.Context)
+	read := func() {
+		select {
+		case <-cancel:
+			raise(model.Context.Err())
+		default:
+		}
+		if _, err := io.ReadFull(in, bits[:]); err == io.EOF {
+			/* the encoder flushes less than 16 bits, so only that many bits past the end are valid */
+			bits[0], eof = 0, 1
+		} else if err != nil {
+			raise(err)
+		}
+	}
 
 	input := func() {
 		if code <<= 1; bits[0]&mask != 0 {
 			code |= 1
 		}
 
+		if past += eof; past > 16 {
+			raise(ErrTruncated)
+		}
 		if mask >>= 1; mask == 0 {
-			if n, _ := in.Read(bits[:]); n > 0 {
-				mask = 0x80
-			}
+			read()
+			mask = 0x80
 		}
 	}
 
-	in.Read(bits[:])
+	read()
 	for i := 0; i < 16; i++ {
 		input()
 	}
@@ -221,6 +243,8 @@ func (model Model) Decode(in io.Reader) {
 			s = model.Output(uint16(((uint32(code-low)+1)*model.Scale - 1) / hl))
 		}
 	}
+
+	return nil
 }
 
 func (model Model32) Code(out io.Writer) int {
@@ -300,24 +324,46 @@ func (model Model32) Code(out io.Writer) int {
 	return count
 }
 
-func (model Model32) Decode(in io.Reader) {
+// Decode decodes symbols from in until the model signals the end of output.
+// ErrTruncated is returned if in runs out of bits before that.
+func (model Model32) Decode(in io.Reader) (err error) {
+	defer recoverDecode(&err)
+
 	var bits [1]byte
 	var mask uint8 = 0x80
 	var code uint32
+	var eof, past uint32
+
+	cancel := done(model.Context)
+	read := func() {
+		select {
+		case <-cancel:
+			raise(model.Context.Err())
+		default:
+		}
+		if _, err := io.ReadFull(in, bits[:]); err == io.EOF {
+			/* the encoder flushes less than 32 bits, so only that many bits past the end are valid */
+			bits[0], eof = 0, 1
+		} else if err != nil {
+			raise(err)
+		}
+	}
 
 	input := func() {
 		if code <<= 1; bits[0]&mask != 0 {
 			code |= 1
 		}
 
+		if past += eof; past > 32 {
+			raise(ErrTruncated)
+		}
 		if mask >>= 1; mask == 0 {
-			if n, _ := in.Read(bits[:]); n > 0 {
-				mask = 0x80
-			}
+			read()
+			mask = 0x80
 		}
 	}
 
-	in.Read(bits[:])
+	read()
 	for i := 0; i < 32; i++ {
 		input()
 	}
@@ -366,4 +412,6 @@ func (model Model32) Decode(in io.Reader) {
 			s = model.Output(uint32(((uint64(code-low)+1)*model.Scale - 1) / hl))
 		}
 	}
+
+	return nil
 }
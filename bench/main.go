@@ -76,246 +76,76 @@ func Entropy(input []byte) float64 {
 	return -entropy
 }
 
-type Coder16Configuration struct {
-	Name       string
-	Compress   func(coder *compress.Coder16, buffer *bytes.Buffer)
-	Uncompress func(coder *compress.Coder16, buffer *bytes.Buffer)
-}
-
-var coder16Configurations = [...]Coder16Configuration{
-	{
-		Name: "adaptive coder 16",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.AdaptiveCoder().Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.AdaptiveDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "adaptive predictive coder 16",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.AdaptivePredictiveCoder().Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.AdaptivePredictiveDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "adaptive bit coder 16",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.AdaptiveBitCoder().Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.AdaptiveBitDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "adaptive predictive bit coder 16",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.AdaptivePredictiveBitCoder().Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.AdaptivePredictiveBitDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "filtered adaptive bit coder 16",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptiveBitCoder().Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptiveBitDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "filtered adaptive predictive bit coder 16",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptivePredictiveBitCoder().Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptivePredictiveBitDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "filtered adaptive coder 16",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptiveCoder(compress.NewCDF16(0, true)).Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptiveDecoder(compress.NewCDF16(0, true)).Decode(buffer)
-		},
-	},
-	{
-		Name: "filtered adaptive predictive coder 16",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptiveCoder(compress.NewCDF16(2, true)).Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptiveDecoder(compress.NewCDF16(2, true)).Decode(buffer)
-		},
-	},
-	{
-		Name: "filtered adaptive predictive coder 32",
-		Compress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptiveCoder32(compress.NewCDF32(2, true)).Code(buffer)
-		},
-		Uncompress: func(coder *compress.Coder16, buffer *bytes.Buffer) {
-			coder.FilteredAdaptiveDecoder32(compress.NewCDF32(2, true)).Decode(buffer)
-		},
-	},
-}
-
-type Configuration struct {
-	Name       string
-	Compress   func(in chan []byte, buffer *bytes.Buffer)
-	Uncompress func(in chan []byte, buffer *bytes.Buffer)
-}
-
-var configurations = [...]Configuration{
-	{
-		Name: "burrows-wheeler adaptive coder 16",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontRunLengthCoder().AdaptiveCoder().Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontRunLengthDecoder().AdaptiveDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "burrows-wheeler adaptive predictive coder 16",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontRunLengthCoder().AdaptivePredictiveCoder().Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontRunLengthDecoder().AdaptivePredictiveDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "burrows-wheeler adaptive bit coder 16",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontCoder().AdaptiveBitCoder().Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontDecoder().AdaptiveBitDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "burrows-wheeler adaptive predictive bit coder 16",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontCoder().AdaptivePredictiveBitCoder().Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontDecoder().AdaptivePredictiveBitDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "burrows-wheeler filtered adaptive bit coder 16",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontCoder().FilteredAdaptiveBitCoder().Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontDecoder().FilteredAdaptiveBitDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "burrows-wheeler filtered adaptive predictive bit coder 16",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontCoder().FilteredAdaptivePredictiveBitCoder().Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontDecoder().FilteredAdaptivePredictiveBitDecoder().Decode(buffer)
-		},
-	},
-	{
-		Name: "burrows-wheeler filtered adaptive coder 16",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontCoder().
-				FilteredAdaptiveCoder(compress.NewCDF16(0, true)).Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontDecoder().
-				FilteredAdaptiveDecoder(compress.NewCDF16(0, true)).Decode(buffer)
-		},
-	},
-	{
-		Name: "burrows-wheeler filtered adaptive predictive coder 16",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontCoder().
-				FilteredAdaptiveCoder(compress.NewCDF16(2, true)).Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontDecoder().
-				FilteredAdaptiveDecoder(compress.NewCDF16(2, true)).Decode(buffer)
-		},
-	},
-}
-
-var configurations32 = [...]Configuration{
-	{
-		Name: "burrows-wheeler adaptive coder 32",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontRunLengthCoder().AdaptiveCoder32().Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontRunLengthDecoder().AdaptiveDecoder32().Decode(buffer)
-		},
-	},
-	{
-		Name: "burrows-wheeler adaptive predictive coder 32",
-		Compress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerCoder(in).MoveToFrontRunLengthCoder().AdaptivePredictiveCoder32().Code(buffer)
-		},
-		Uncompress: func(in chan []byte, buffer *bytes.Buffer) {
-			compress.BijectiveBurrowsWheelerDecoder(in).MoveToFrontRunLengthDecoder().AdaptivePredictiveDecoder32().Decode(buffer)
-		},
-	},
-}
-
-func Compress(input []byte) {
-	for c := range coder16Configurations {
-		/* compress */
-		start, data := time.Now(), make([]uint16, len(input))
-		for i := range input {
-			data[i] = uint16(input[i])
-		}
-		symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
-		symbols <- data
-		close(symbols)
-		coder16Configurations[c].Compress(&compress.Coder16{Alphabit: 256, Input: symbols}, buffer)
-		fmt.Println(coder16Configurations[c].Name)
-		fmt.Printf("compressed=%v\n", buffer.Len())
-		fmt.Printf("ratio=%v\n", float64(buffer.Len())/float64(len(input)))
-		fmt.Println(time.Now().Sub(start).String())
-
-		/* decompress */
-		start = time.Now()
-		out, i := make([]byte, len(input)), 0
-		output := func(symbol uint16) bool {
-			out[i] = byte(symbol)
-			i++
-			return i >= len(data)
-		}
-		coder16Configurations[c].Uncompress(&compress.Coder16{Alphabit: 256, Output: output}, buffer)
-		if bytes.Compare(input, out) != 0 {
-			fmt.Println("decompression didn't work")
-			failed = append(failed, coder16Configurations[c].Name)
-		} else {
-			fmt.Println("decompression worked")
+// pipelines are the specs benchmarked by Compress; compress.Stages lists the stages
+var pipelines = [...]string{
+	"identity|adaptive",
+	"identity|adaptive-predictive",
+	"identity|adaptive-bit",
+	"identity|adaptive-predictive-bit",
+	"identity|filtered-adaptive-bit",
+	"identity|filtered-adaptive-predictive-bit",
+	"identity|mixing-bit",
+	"identity|ctw-bit",
+	"identity|ppm",
+	"identity|ppm(order=2)",
+	"identity|ppm(order=6,nodes=65536,policy=1)",
+	"identity|ppm-star",
+	"identity|ppm-star(window=65536,nodes=65536,policy=1)",
+	"identity|cdf16(depth=0)",
+	"identity|cdf16(depth=2)",
+	"identity|cdf16(depth=3,nodes=4096,policy=1)",
+	"identity|cdf16(depth=3,nodes=4096,hashed=1)",
+	"identity|cdf32(depth=2)",
+	"bwt|mtf-rle|adaptive",
+	"bbwt|mtf-rle|adaptive",
+	"bbwt|mtf-rle|adaptive-predictive",
+	"bbwt|mtf|adaptive-bit",
+	"bbwt|mtf|adaptive-predictive-bit",
+	"bbwt|mtf|filtered-adaptive-bit",
+	"bbwt|mtf|filtered-adaptive-predictive-bit",
+	"bbwt|mtf|mixing-bit",
+	"bbwt|mtf|ctw-bit(depth=8)",
+	"bbwt|mtf|ctw-bit",
+	"bbwt|mtf|ctw-bit(depth=22)",
+	"bbwt|mtf|sse-filtered-adaptive-predictive-bit",
+	"bbwt|mtf-rle|sse-adaptive-predictive-bit",
+	"bbwt|mtf|cdf16(depth=0)",
+	"bbwt|mtf|cdf16(depth=2)",
+	"bbwt|mtf-rle|cdf16(depth=2)",
+	"bbwt|mtf-rle|fenwick",
+	"bbwt|mtf-rle|range-adaptive",
+	"bbwt|mtf|range-cdf16(depth=2)",
+	"bbwt|mtf-rle|rans",
+	"bbwt|mtf-rle|rans(block=16384)",
+	"bbwt|mtf-rle|tans",
+	"bbwt|mtf-rle|tans(block=16384,states=4)",
+	"bbwt|mtf-rle|huffman",
+	"bbwt|mtf-rle|huffman(tables=1)",
+}
+
+// pipelines32 are the specs benchmarked by Compress32
+var pipelines32 = [...]string{
+	"bbwt|mtf-rle|adaptive32",
+	"bbwt|mtf-rle|adaptive-predictive32",
+	"bbwt|mtf-rle|fenwick32",
+	"bbwt|mtf-rle|range-adaptive32",
+}
+
+func Bench(input []byte, specs []string) {
+	for _, spec := range specs {
+		codec, err := compress.ParseSpec(spec)
+		if err != nil {
+			log.Fatal(err)
 		}
-		fmt.Println(time.Now().Sub(start).String())
-		fmt.Println()
-	}
 
-	for c := range configurations {
 		/* compress */
 		start, in, data := time.Now(), make(chan []byte, 1), make([]byte, len(input))
 		copy(data, input)
 		buffer := &bytes.Buffer{}
 		in <- data
 		close(in)
-		configurations[c].Compress(in, buffer)
-		fmt.Println(configurations[c].Name)
+		codec.Coder(nil, in).Code(buffer)
+		fmt.Println(codec)
 		fmt.Printf("compressed=%v\n", buffer.Len())
 		fmt.Printf("ratio=%v\n", float64(buffer.Len())/float64(len(input)))
 		fmt.Println(time.Now().Sub(start).String())
@@ -325,75 +155,24 @@ func Compress(input []byte) {
 		uncompressed := make([]byte, len(input))
 		in <- uncompressed
 		close(in)
-		configurations[c].Uncompress(in, buffer)
-		if bytes.Compare(input, uncompressed) != 0 {
+		err = codec.Decoder(nil, in).Decode(buffer)
+		if err != nil || bytes.Compare(input, uncompressed) != 0 {
 			fmt.Println("decompression didn't work")
-			failed = append(failed, configurations[c].Name)
+			failed = append(failed, spec)
 		} else {
 			fmt.Println("decompression worked")
 		}
 		fmt.Println(time.Now().Sub(start).String())
 		fmt.Println()
 	}
+}
 
-	/* compress */
-	start, in, data := time.Now(), make(chan []byte, 1), make([]byte, len(input))
-	copy(data, input)
-	buffer := &bytes.Buffer{}
-	in <- data
-	close(in)
-	code, sentinels := compress.BurrowsWheelerCoder(in)
-	code.MoveToFrontRunLengthCoder().AdaptiveCoder().Code(buffer)
-	fmt.Println("suffix tree adaptive coder")
-	fmt.Printf("compressed=%v\n", buffer.Len())
-	fmt.Printf("ratio=%v\n", float64(buffer.Len())/float64(len(input)))
-	fmt.Println(time.Now().Sub(start).String())
-
-	/* decompress */
-	start, in = time.Now(), make(chan []byte, 1)
-	uncompressed := make([]byte, len(input))
-	in <- uncompressed
-	close(in)
-	compress.BurrowsWheelerDecoder(in, sentinels).MoveToFrontRunLengthDecoder().AdaptiveDecoder().Decode(buffer)
-	if bytes.Compare(input, uncompressed) != 0 {
-		fmt.Println("decompression didn't work")
-		failed = append(failed, "suffix tree adaptive coder")
-	} else {
-		fmt.Println("decompression worked")
-	}
-	fmt.Println(time.Now().Sub(start).String())
-	fmt.Println()
+func Compress(input []byte) {
+	Bench(input, pipelines[:])
 }
 
 func Compress32(input []byte) {
-	for c := range configurations32 {
-		/* compress */
-		start, in, data := time.Now(), make(chan []byte, 1), make([]byte, len(input))
-		copy(data, input)
-		buffer := &bytes.Buffer{}
-		in <- data
-		close(in)
-		configurations32[c].Compress(in, buffer)
-		fmt.Println(configurations32[c].Name)
-		fmt.Printf("compressed=%v\n", buffer.Len())
-		fmt.Printf("ratio=%v\n", float64(buffer.Len())/float64(len(input)))
-		fmt.Println(time.Now().Sub(start).String())
-
-		/* decompress */
-		start, in = time.Now(), make(chan []byte, 1)
-		uncompressed := make([]byte, len(input))
-		in <- uncompressed
-		close(in)
-		configurations32[c].Uncompress(in, buffer)
-		if bytes.Compare(input, uncompressed) != 0 {
-			fmt.Println("decompression didn't work")
-			failed = append(failed, configurations32[c].Name)
-		} else {
-			fmt.Println("decompression worked")
-		}
-		fmt.Println(time.Now().Sub(start).String())
-		fmt.Println()
-	}
+	Bench(input, pipelines32[:])
 }
 
 func Test(file string) {
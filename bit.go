@@ -0,0 +1,207 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+// BitPredictor models the bits of symbols, most significant first, for
+// BitCoder and BitDecoder. Predict and Update alternate, starting with Predict.
+type BitPredictor interface {
+	// Predict returns the probability that the next bit is a zero out of scale
+	Predict() (zero, scale uint16)
+	// Update learns the bit that followed the last prediction
+	Update(b uint16)
+}
+
+// BitCoder codes the bits of each symbol with the probabilities of predictor
+func (coder Coder16) BitCoder(predictor BitPredictor) Model {
+	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
+
+	go func() {
+		defer close(out)
+		cancel := done(coder.Context)
+
+		buffer := [BUFFER_POOL_SIZE]Symbol{}
+
+		highest := uint32(0)
+		for a := coder.Alphabit - 1; a > 0; a >>= 1 {
+			highest++
+		}
+
+		current, offset, index, mask := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0, uint16(1)<<(highest-1)
+		for input := range coder.Input {
+			for _, s := range input {
+				for bit := mask; bit > 0; bit >>= 1 {
+					high, scale := predictor.Predict()
+					b, low := uint16(0), uint16(0)
+					if bit&s != 0 {
+						b, low, high = 1, high, scale
+					}
+
+					current[index], index = Symbol{Scale: scale, Low: low, High: high}, index+1
+					if index == BUFFER_SIZE {
+						select {
+						case out <- current:
+						case <-cancel:
+							return
+						}
+						next := offset + BUFFER_SIZE
+						current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
+					}
+
+					predictor.Update(b)
+				}
+			}
+		}
+
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
+	}()
+
+	return Model{Input: out, Context: coder.Context}
+}
+
+func (decoder Coder16) BitDecoder(predictor BitPredictor) Model {
+	highest := uint32(0)
+	for a := decoder.Alphabit - 1; a > 0; a >>= 1 {
+		highest++
+	}
+	mask := uint16(1) << (highest - 1)
+	bit, bits := mask, uint16(0)
+	zero, scale := predictor.Predict()
+
+	lookup := func(code uint16) Symbol {
+		low, high, b := uint16(0), uint16(0), uint16(0)
+		if code < zero {
+			high, bit = zero, bit>>1
+		} else {
+			low, high, bits, bit, b = zero, scale, bits|bit, bit>>1, 1
+		}
+
+		predictor.Update(b)
+
+		if bit == 0 {
+			if decoder.Output(bits) {
+				return Symbol{}
+			}
+			bits, bit = 0, mask
+		}
+
+		zero, scale = predictor.Predict()
+		return Symbol{Scale: scale, Low: low, High: high}
+	}
+
+	return Model{Scale: uint32(scale), Output: lookup, Context: decoder.Context}
+}
+
+// halve halves the counts of a bit once their sum reaches MAX_SCALE16
+func halve(counts *[2]uint16, scale uint16) {
+	if scale >= MAX_SCALE16 {
+		counts[0] >>= 1
+		counts[1] >>= 1
+		if counts[0] == 0 {
+			counts[0] = 1
+		}
+		if counts[1] == 0 {
+			counts[1] = 1
+		}
+	}
+}
+
+type adaptiveBit struct {
+	table [2]uint16
+}
+
+// NewAdaptiveBitPredictor counts the zeros and ones
+func NewAdaptiveBitPredictor() BitPredictor {
+	return &adaptiveBit{table: [2]uint16{1, 1}}
+}
+
+func (a *adaptiveBit) Predict() (uint16, uint16) {
+	return a.table[0], a.table[0] + a.table[1]
+}
+
+func (a *adaptiveBit) Update(b uint16) {
+	scale := a.table[0] + a.table[1]
+	a.table[b]++
+	halve(&a.table, scale)
+}
+
+type adaptivePredictiveBit struct {
+	table   [][2]uint16
+	context uint16
+}
+
+// NewAdaptivePredictiveBitPredictor counts the zeros and ones after each of the previous 16 bits
+func NewAdaptivePredictiveBitPredictor() BitPredictor {
+	table := make([][2]uint16, 65536)
+	for i, _ := range table {
+		table[i][0] = 1
+		table[i][1] = 1
+	}
+	return &adaptivePredictiveBit{table: table}
+}
+
+func (a *adaptivePredictiveBit) Predict() (uint16, uint16) {
+	counts := &a.table[a.context]
+	return counts[0], counts[0] + counts[1]
+}
+
+func (a *adaptivePredictiveBit) Update(b uint16) {
+	counts := &a.table[a.context]
+	scale := counts[0] + counts[1]
+	counts[b]++
+	halve(counts, scale)
+	a.context = b | (a.context << 1)
+}
+
+type filteredBit struct {
+	p1 uint16
+}
+
+// NewFilteredAdaptiveBitPredictor moves the probability of a one a fraction of the way to each bit
+// https://fgiesen.wordpress.com/2015/05/26/models-for-adaptive-arithmetic-coding/
+func NewFilteredAdaptiveBitPredictor() BitPredictor {
+	return &filteredBit{p1: filterScale / 2}
+}
+
+func (f *filteredBit) Predict() (uint16, uint16) {
+	return filterScale - f.p1, filterScale
+}
+
+func (f *filteredBit) Update(b uint16) {
+	if b == 0 {
+		f.p1 -= f.p1 >> filterShift
+	} else {
+		f.p1 += (filterScale - f.p1) >> filterShift
+	}
+}
+
+type filteredPredictiveBit struct {
+	table   []uint16
+	context uint16
+}
+
+// NewFilteredAdaptivePredictiveBitPredictor filters the probability of a one after each of the previous 16 bits
+func NewFilteredAdaptivePredictiveBitPredictor() BitPredictor {
+	table := make([]uint16, 65536)
+	for i, _ := range table {
+		table[i] = filterScale / 2
+	}
+	return &filteredPredictiveBit{table: table}
+}
+
+func (f *filteredPredictiveBit) Predict() (uint16, uint16) {
+	return filterScale - f.table[f.context], filterScale
+}
+
+func (f *filteredPredictiveBit) Update(b uint16) {
+	if b == 0 {
+		f.table[f.context] -= f.table[f.context] >> filterShift
+	} else {
+		f.table[f.context] += (filterScale - f.table[f.context]) >> filterShift
+	}
+	f.context = b | (f.context << 1)
+}
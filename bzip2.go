@@ -0,0 +1,240 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	BZIP2_BLOCK_MAGIC = 0x314159265359
+	BZIP2_END_MAGIC   = 0x177245385090
+	BZIP2_GROUP       = 50
+	/* bzip2 limits the codes to 17 bits when it builds them */
+	BZIP2_MAX_LENGTH = 17
+)
+
+// ErrLevel is returned for a bzip2 level that is not in 1..9
+var ErrLevel = errors.New("compress: bzip2 level must be in 1..9")
+
+// bzip2Table is the table of the CRC32 of bzip2, which is not bit reversed
+var bzip2Table = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
+
+// errorWriter keeps the first error of w, since bitWriter ignores them
+type errorWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errorWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
+
+// Bzip2Writer is an io.WriteCloser that compresses to the bzip2 format, which
+// compress/bzip2 and the bzip2 tool read. Runs of 4 to 255 equal bytes are
+// shortened as bzip2 does before each block of up to level*100000 bytes is
+// sorted, moved to front with runs of zeros coded in RUNA and RUNB, and
+// Huffman coded with up to 6 tables chosen for each group of 50 symbols.
+type Bzip2Writer struct {
+	out      *errorWriter
+	w        *bitWriter
+	level    int
+	block    []byte
+	crc      uint32
+	combined uint32
+	run      byte
+	length   int
+	started  bool
+	closed   bool
+}
+
+// NewBzip2Writer returns a Bzip2Writer that compresses to w with blocks of
+// level*100000 bytes. The caller must Close the Bzip2Writer to end the stream.
+func NewBzip2Writer(w io.Writer, level int) (*Bzip2Writer, error) {
+	if level < 1 || level > 9 {
+		return nil, ErrLevel
+	}
+	out := &errorWriter{w: w}
+	return &Bzip2Writer{out: out, w: &bitWriter{out: out, buffer: make([]byte, 0, 1<<12)}, level: level,
+		block: make([]byte, 0, level*100000), crc: 0xffffffff}, nil
+}
+
+// Write shortens the runs of p into the current block, compressing the block whenever it fills up
+func (z *Bzip2Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, ErrClosed
+	}
+	if z.out.err != nil {
+		return 0, z.out.err
+	}
+	for _, b := range p {
+		if z.length == 0 || b != z.run || z.length == 255 {
+			z.flushRun()
+			z.run, z.length = b, 0
+		}
+		z.crc, z.length = z.crc<<8^bzip2Table[byte(z.crc>>24)^b], z.length+1
+	}
+	return len(p), z.out.err
+}
+
+// flushRun adds the current run to the block: up to 3 bytes as they are and
+// longer runs as 4 bytes and the number of bytes after them
+func (z *Bzip2Writer) flushRun() {
+	if z.length == 0 {
+		return
+	}
+	for i := 0; i < z.length && i < 4; i++ {
+		z.block = append(z.block, z.run)
+	}
+	if z.length >= 4 {
+		z.block = append(z.block, byte(z.length-4))
+	}
+	z.length = 0
+	/* bzip2 leaves room for a run after the last one */
+	if len(z.block) >= z.level*100000-19 {
+		z.flushBlock()
+	}
+}
+
+func (z *Bzip2Writer) header() {
+	if !z.started {
+		z.started = true
+		z.w.write('B'<<16|'Z'<<8|'h', 24)
+		z.w.write('0'+uint32(z.level), 8)
+	}
+}
+
+// flushBlock compresses the block and starts the next one
+func (z *Bzip2Writer) flushBlock() {
+	z.header()
+	if len(z.block) == 0 {
+		return
+	}
+	crc := ^z.crc
+	z.combined = (z.combined<<1 | z.combined>>31) ^ crc
+	bzip2Block(z.w, z.block, crc)
+	z.block, z.crc = z.block[:0], 0xffffffff
+}
+
+// Close compresses the last block and ends the stream with the CRC of the
+// CRCs of the blocks. It does not close the underlying writer.
+func (z *Bzip2Writer) Close() error {
+	if z.closed {
+		return z.out.err
+	}
+	z.closed = true
+	z.flushRun()
+	z.flushBlock()
+	z.w.write(BZIP2_END_MAGIC>>24, 24)
+	z.w.write(BZIP2_END_MAGIC&0xffffff, 24)
+	z.w.write(z.combined, 32)
+	z.w.close()
+	return z.out.err
+}
+
+// bzip2Block writes a compressed block: the block is sorted by its
+// rotations, which is the Burrows-Wheeler transform of bzip2, and the bytes
+// that occur are numbered in order before they are moved to front
+func bzip2Block(w *bitWriter, block []byte, crc uint32) {
+	n, origin := len(block), 0
+	sa := SuffixArray(append(append(make([]byte, 0, 2*n), block...), block...))
+	transformed := make([]byte, 0, n)
+	for _, p := range sa {
+		if p >= int32(n) {
+			continue
+		}
+		if p == 0 {
+			origin = len(transformed)
+		}
+		transformed = append(transformed, block[(int(p)+n-1)%n])
+	}
+
+	var used [256]bool
+	var sequence [256]byte
+	for _, b := range block {
+		used[b] = true
+	}
+	count := 0
+	for b, u := range used {
+		if u {
+			sequence[b], count = byte(count), count+1
+		}
+	}
+	for i, b := range transformed {
+		transformed[i] = sequence[b]
+	}
+
+	input := make(chan []byte, 1)
+	input <- transformed
+	close(input)
+	symbols := []uint16(nil)
+	for s := range (Coder8{Alphabit: 256, Input: input}).MoveToFrontRunLengthCoder().Input {
+		symbols = append(symbols, s...)
+	}
+	symbols = append(symbols, uint16(count+1))
+
+	size := count + 2
+	tables := 6
+	switch {
+	case len(symbols) < 200:
+		tables = 2
+	case len(symbols) < 600:
+		tables = 3
+	case len(symbols) < 1200:
+		tables = 4
+	case len(symbols) < 2400:
+		tables = 5
+	}
+	alphabet := make([]bool, size)
+	for i := range alphabet {
+		alphabet[i] = true
+	}
+	lengths, selectors := huffmanTables(symbols, alphabet, tables, BZIP2_GROUP, BZIP2_MAX_LENGTH)
+
+	w.write(BZIP2_BLOCK_MAGIC>>24, 24)
+	w.write(BZIP2_BLOCK_MAGIC&0xffffff, 24)
+	w.write(crc, 32)
+	w.write(0, 1)
+	w.write(uint32(origin), 24)
+	ranges := uint32(0)
+	for i := 0; i < 16; i++ {
+		for _, u := range used[16*i : 16*i+16] {
+			if u {
+				ranges |= 0x8000 >> uint(i)
+				break
+			}
+		}
+	}
+	w.write(ranges, 16)
+	for i := 0; i < 16; i++ {
+		if ranges&(0x8000>>uint(i)) != 0 {
+			for _, u := range used[16*i : 16*i+16] {
+				w.write(bit(u), 1)
+			}
+		}
+	}
+	w.write(uint32(tables), 3)
+	w.write(uint32(len(selectors)), 15)
+	writeHuffman(w, symbols, alphabet, BZIP2_GROUP, lengths, selectors)
+}
@@ -1,6 +1,9 @@
 package compress
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 const (
 	// CDF16Fixed is the shift for 16 bit coders
@@ -15,23 +18,76 @@ const (
 	CDF32Scale = 1 << CDF32Fixed
 	// CDF32Rate is the damping factor for 32 bit coder
 	CDF32Rate = 5
+	// CDF16MaxSize is the largest alphabet of a CDF16, 8192 symbols, which gives every symbol at least one count
+	// of CDF16Scale; larger alphabets need a CDF32
+	CDF16MaxSize = CDF16Scale
+	// CDF32MaxSize is the largest alphabet of a CDF32, which covers every uint16 symbol
+	CDF32MaxSize = 1 << 16
+	// DefaultHashedNodes is the budget of a hashed context tree when Budget.Nodes is zero
+	DefaultHashedNodes = 1 << 12
+	// CDFMaxDepth is the longest context of the cdf16 and cdf32 stages
+	CDFMaxDepth = 32
 )
 
+// Policy is what a context tree does when it runs out of nodes
+type Policy int
+
+const (
+	// PolicyReset drops every context below the root and starts over
+	PolicyReset Policy = iota
+	// PolicyPrune drops the least recently used contexts until half of the nodes are free
+	PolicyPrune
+	// PolicyFreeze stops adding contexts; the existing contexts keep adapting
+	PolicyFreeze
+)
+
+// Budget bounds the memory of a context tree. Nodes is the largest number of
+// nodes, counting the root, with zero meaning unbounded, and Policy is applied
+// when they run out. A Hashed tree instead keeps the contexts below the root in
+// a table of Nodes-1 slots indexed by a hash of the context, where a new
+// context takes over the slot of the old one.
+type Budget struct {
+	Nodes  int
+	Policy Policy
+	Hashed bool
+}
+
+// slots returns the number of slots in the table of a hashed tree
+func (b Budget) slots() int {
+	slots := b.Nodes
+	if slots <= 0 {
+		slots = DefaultHashedNodes
+	}
+	if slots--; slots < 1 {
+		slots = 1
+	}
+	return slots
+}
+
+// hashContext extends the hash of a context by one symbol
+func hashContext(h uint64, s uint16) uint64 {
+	return (h + uint64(s) + 1) * 0x9e3779b97f4a7c15
+}
+
 type Filtered16 interface {
 	Model() []uint16
 	Update(s uint16)
 }
 
+// Node16 is a context of a CDF16. Used is the update in which the context was
+// last seen and Check is the hash of the context in a hashed table.
 type Node16 struct {
 	Model    []uint16
 	Children map[uint16]*Node16
+	Used     uint64
+	Check    uint64
 }
 
+// NewNode16 returns a node whose CDF is uniform over size symbols
 func NewNode16(size int) *Node16 {
-	model, children, sum := make([]uint16, size+1), make(map[uint16]*Node16), 0
+	model, children := make([]uint16, size+1), make(map[uint16]*Node16)
 	for i := range model {
-		model[i] = uint16(sum)
-		sum += 32
+		model[i] = uint16(uint64(i) * CDF16Scale / uint64(size))
 	}
 	return &Node16{
 		Model:    model,
@@ -39,138 +95,233 @@ func NewNode16(size int) *Node16 {
 	}
 }
 
+// CDF16 is a tree of adaptive CDFs for the contexts of up to len(Context)
+// previous symbols. Nodes is the number of nodes in use, Clock counts the
+// updates and Table holds the contexts of a hashed tree.
 type CDF16 struct {
 	Size    int
 	Root    *Node16
 	Context []uint16
 	First   int
-	Mixin   [][]uint16
 	Verify  bool
+	Budget  Budget
+	Nodes   int
+	Clock   uint64
+	Table   []*Node16
 }
 
 type CDF16Maker func(size int) Filtered16
 
 func NewCDF16(depth int, verify bool) CDF16Maker {
+	return NewBoundedCDF16(depth, verify, Budget{})
+}
+
+// NewBoundedCDF16 returns the maker of CDF16 trees whose memory is bounded by budget.
+// The trees model alphabets of 2 to CDF16MaxSize symbols; NewBoundedCDF32 models up to CDF32MaxSize.
+func NewBoundedCDF16(depth int, verify bool, budget Budget) CDF16Maker {
 	return func(size int) Filtered16 {
-		if size != 256 {
-			panic("size is not 256")
+		if size < 2 || size > CDF16MaxSize {
+			panic(fmt.Sprintf("compress: CDF16 alphabet of %d symbols is not in 2..%d; use a CDF32", size, CDF16MaxSize))
 		}
-		root, mixin := NewNode16(size), make([][]uint16, size)
-
-		for i := range mixin {
-			sum, m := 0, make([]uint16, size+1)
-			for j := range m {
-				m[j] = uint16(sum)
-				sum++
-				if j == i {
-					sum += CDF16Scale - size
-				}
-			}
-			mixin[i] = m
-		}
-
-		return &CDF16{
+		c := &CDF16{
 			Size:    size,
-			Root:    root,
+			Root:    NewNode16(size),
 			Context: make([]uint16, depth),
-			Mixin:   mixin,
 			Verify:  verify,
+			Budget:  budget,
+			Nodes:   1,
 		}
+		c.table()
+		return c
+	}
+}
+
+// table allocates the table of a hashed tree
+func (c *CDF16) table() {
+	if c.Budget.Hashed {
+		c.Table = make([]*Node16, c.Budget.slots())
 	}
 }
 
 func (c *CDF16) Model() []uint16 {
-	context := c.Context
+	context, current, n := c.Context, c.First, c.Root
 	length := len(context)
-	var lookUp func(n *Node16, current, depth int) *Node16
-	lookUp = func(n *Node16, current, depth int) *Node16 {
-		if depth >= length {
-			return n
+	if c.Table != nil {
+		h := uint64(0)
+		for depth := 0; depth < length; depth++ {
+			h = hashContext(h, context[current])
+			node := c.Table[h%uint64(len(c.Table))]
+			if node == nil || node.Check != h {
+				break
+			}
+			n, current = node, (current+1)%length
 		}
+		return n.Model
+	}
 
+	for depth := 0; depth < length; depth++ {
 		node := n.Children[context[current]]
 		if node == nil {
-			return n
-		}
-		child := lookUp(node, (current+1)%length, depth+1)
-		if child == nil {
-			return n
+			break
 		}
-		return child
+		n, current = node, (current+1)%length
 	}
-
-	return lookUp(c.Root, c.First, 0).Model
+	return n.Model
 }
 
-func (c *CDF16) Update(s uint16) {
-	context, first, mixin := c.Context, c.First, c.Mixin[s]
-	length := len(context)
-	var update func(n *Node16, current, depth int)
-	update = func(n *Node16, current, depth int) {
-		model := n.Model
-		size := len(model) - 1
-
-		if c.Verify {
-			for i := 1; i < size; i++ {
-				a, b := int(model[i]), int(mixin[i])
-				if a < 0 {
-					panic("a is less than zero")
-				}
-				if b < 0 {
-					panic("b is less than zero")
-				}
-				model[i] = uint16(a + ((b - a) >> CDF16Rate))
+// adapt moves model towards the mixin of s, the CDF that gives every symbol
+// a count of one and s the rest of the scale. As the entries of the mixin are
+// at least one apart, so are the entries of the model.
+func (c *CDF16) adapt(model []uint16, s uint16) {
+	size := len(model) - 1
+	extra := CDF16Scale - size
+
+	if c.Verify {
+		for i := 1; i < size; i++ {
+			a, b := int(model[i]), int(i)
+			if i > int(s) {
+				b += extra
 			}
-			if model[size] != CDF16Scale {
-				panic("cdf scale is incorrect")
+			if a < 0 {
+				panic("a is less than zero")
 			}
-			for i := 1; i < len(model); i++ {
-				if a, b := model[i], model[i-1]; a < b {
-					panic(fmt.Sprintf("invalid cdf %v,%v < %v,%v", i, a, i-1, b))
-				} else if a == b {
-					panic(fmt.Sprintf("invalid cdf %v,%v = %v,%v", i, a, i-1, b))
-				}
+			model[i] = uint16(a + ((b - a) >> CDF16Rate))
+		}
+		if model[size] != CDF16Scale {
+			panic("cdf scale is incorrect")
+		}
+		for i := 1; i < len(model); i++ {
+			if a, b := model[i], model[i-1]; a < b {
+				panic(fmt.Sprintf("invalid cdf %v,%v < %v,%v", i, a, i-1, b))
+			} else if a == b {
+				panic(fmt.Sprintf("invalid cdf %v,%v = %v,%v", i, a, i-1, b))
 			}
-		} else {
-			for i := 1; i < size; i++ {
-				a, b := int(model[i]), int(mixin[i])
-				model[i] = uint16(a + ((b - a) >> CDF16Rate))
+		}
+	} else {
+		for i := 1; i < size; i++ {
+			a, b := int(model[i]), int(i)
+			if i > int(s) {
+				b += extra
 			}
+			model[i] = uint16(a + ((b - a) >> CDF16Rate))
 		}
+	}
+}
 
-		if depth >= length {
-			return
+func (c *CDF16) Update(s uint16) {
+	context, current := c.Context, c.First
+	length, budget := len(context), c.Budget.Nodes
+	c.Clock++
+
+	if c.Table != nil {
+		c.adapt(c.Root.Model, s)
+		h := uint64(0)
+		for depth := 0; depth < length; depth++ {
+			h = hashContext(h, context[current])
+			slot := &c.Table[h%uint64(len(c.Table))]
+			if *slot == nil {
+				c.Nodes++
+			}
+			if *slot == nil || (*slot).Check != h {
+				*slot = NewNode16(c.Size)
+				(*slot).Check = h
+			}
+			c.adapt((*slot).Model, s)
+			current = (current + 1) % length
+		}
+	} else {
+		/* make room for the longest path before walking it */
+		if budget > 0 && c.Nodes+length > budget {
+			switch c.Budget.Policy {
+			case PolicyReset:
+				c.Root.Children, c.Nodes = make(map[uint16]*Node16), 1
+			case PolicyPrune:
+				c.prune()
+			}
 		}
 
-		node := n.Children[context[current]]
-		if node == nil {
-			node = NewNode16(size)
-			n.Children[context[current]] = node
+		n := c.Root
+		for depth := 0; ; depth++ {
+			c.adapt(n.Model, s)
+			n.Used = c.Clock
+			if depth >= length {
+				break
+			}
+
+			node := n.Children[context[current]]
+			if node == nil {
+				if budget > 0 && c.Nodes >= budget {
+					break
+				}
+				node = NewNode16(c.Size)
+				n.Children[context[current]], c.Nodes = node, c.Nodes+1
+			}
+			n, current = node, (current+1)%length
 		}
-		update(node, (current+1)%length, depth+1)
 	}
 
-	update(c.Root, first, 0)
 	if length > 0 {
-		context[first], c.First = s, (first+1)%length
+		context[c.First], c.First = s, (c.First+1)%length
 	}
 }
 
+// prune drops the least recently used contexts, keeping at most half of the budget
+func (c *CDF16) prune() {
+	var used []uint64
+	var collect func(n *Node16)
+	collect = func(n *Node16) {
+		for _, child := range n.Children {
+			used = append(used, child.Used)
+			collect(child)
+		}
+	}
+	collect(c.Root)
+	keep := c.Budget.Nodes/2 - 1
+	if keep < 0 {
+		keep = 0
+	}
+	if len(used) <= keep {
+		return
+	}
+
+	/* a context is used whenever its children are, so dropping the contexts
+	used at or before the cut drops whole subtrees */
+	sort.Slice(used, func(i, j int) bool { return used[i] < used[j] })
+	cut := used[len(used)-keep-1]
+	var drop func(n *Node16)
+	drop = func(n *Node16) {
+		for s, child := range n.Children {
+			if child.Used <= cut {
+				delete(n.Children, s)
+				continue
+			}
+			drop(child)
+		}
+	}
+	drop(c.Root)
+	used = used[:0]
+	collect(c.Root)
+	c.Nodes = len(used) + 1
+}
+
 type Filtered32 interface {
 	Model() []uint32
 	Update(s uint16)
 }
 
+// Node32 is a context of a CDF32
 type Node32 struct {
 	Model    []uint32
 	Children map[uint16]*Node32
+	Used     uint64
+	Check    uint64
 }
 
+// NewNode32 returns a node whose CDF is uniform over size symbols
 func NewNode32(size int) *Node32 {
-	model, children, sum := make([]uint32, size+1), make(map[uint16]*Node32), 0
+	model, children := make([]uint32, size+1), make(map[uint16]*Node32)
 	for i := range model {
-		model[i] = uint32(sum)
-		sum += 2097152
+		model[i] = uint32(uint64(i) * CDF32Scale / uint64(size))
 	}
 	return &Node32{
 		Model:    model,
@@ -178,119 +329,208 @@ func NewNode32(size int) *Node32 {
 	}
 }
 
+// CDF32 is CDF16 for the 32 bit coder
 type CDF32 struct {
 	Size    int
 	Root    *Node32
 	Context []uint16
 	First   int
-	Mixin   [][]uint32
 	Verify  bool
+	Budget  Budget
+	Nodes   int
+	Clock   uint64
+	Table   []*Node32
 }
 
 type CDF32Maker func(size int) Filtered32
 
 func NewCDF32(depth int, verify bool) CDF32Maker {
+	return NewBoundedCDF32(depth, verify, Budget{})
+}
+
+// NewBoundedCDF32 returns the maker of CDF32 trees whose memory is bounded by budget
+func NewBoundedCDF32(depth int, verify bool, budget Budget) CDF32Maker {
 	return func(size int) Filtered32 {
-		if size != 256 {
-			panic("size is not 256")
-		}
-		root, mixin := NewNode32(size), make([][]uint32, size)
-
-		for i := range mixin {
-			sum, m := 0, make([]uint32, size+1)
-			for j := range m {
-				m[j] = uint32(sum)
-				sum++
-				if j == i {
-					sum += CDF32Scale - size
-				}
-			}
-			mixin[i] = m
+		if size < 2 || size > CDF32MaxSize {
+			panic(fmt.Sprintf("compress: CDF32 alphabet of %d symbols is not in 2..%d", size, CDF32MaxSize))
 		}
-
-		return &CDF32{
+		c := &CDF32{
 			Size:    size,
-			Root:    root,
+			Root:    NewNode32(size),
 			Context: make([]uint16, depth),
-			Mixin:   mixin,
 			Verify:  verify,
+			Budget:  budget,
+			Nodes:   1,
 		}
+		c.table()
+		return c
+	}
+}
+
+// table allocates the table of a hashed tree
+func (c *CDF32) table() {
+	if c.Budget.Hashed {
+		c.Table = make([]*Node32, c.Budget.slots())
 	}
 }
 
 func (c *CDF32) Model() []uint32 {
-	context := c.Context
+	context, current, n := c.Context, c.First, c.Root
 	length := len(context)
-	var lookUp func(n *Node32, current, depth int) *Node32
-	lookUp = func(n *Node32, current, depth int) *Node32 {
-		if depth >= length {
-			return n
+	if c.Table != nil {
+		h := uint64(0)
+		for depth := 0; depth < length; depth++ {
+			h = hashContext(h, context[current])
+			node := c.Table[h%uint64(len(c.Table))]
+			if node == nil || node.Check != h {
+				break
+			}
+			n, current = node, (current+1)%length
 		}
+		return n.Model
+	}
 
+	for depth := 0; depth < length; depth++ {
 		node := n.Children[context[current]]
 		if node == nil {
-			return n
-		}
-		child := lookUp(node, (current+1)%length, depth+1)
-		if child == nil {
-			return n
+			break
 		}
-		return child
+		n, current = node, (current+1)%length
 	}
-
-	return lookUp(c.Root, c.First, 0).Model
+	return n.Model
 }
 
-func (c *CDF32) Update(s uint16) {
-	context, first, mixin := c.Context, c.First, c.Mixin[s]
-	length := len(context)
-	var update func(n *Node32, current, depth int)
-	update = func(n *Node32, current, depth int) {
-		model := n.Model
-		size := len(model) - 1
-
-		if c.Verify {
-			for i := 1; i < size; i++ {
-				a, b := int64(model[i]), int64(mixin[i])
-				if a < 0 {
-					panic("a is less than zero")
-				}
-				if b < 0 {
-					panic("b is less than zero")
-				}
-				model[i] = uint32(a + ((b - a) >> CDF32Rate))
+// adapt moves model towards the mixin of s, the CDF that gives every symbol
+// a count of one and s the rest of the scale. As the entries of the mixin are
+// at least one apart, so are the entries of the model.
+func (c *CDF32) adapt(model []uint32, s uint16) {
+	size := len(model) - 1
+	extra := int64(CDF32Scale - size)
+
+	if c.Verify {
+		for i := 1; i < size; i++ {
+			a, b := int64(model[i]), int64(i)
+			if i > int(s) {
+				b += extra
 			}
-			if model[size] != CDF32Scale {
-				panic("cdf scale is incorrect")
+			if a < 0 {
+				panic("a is less than zero")
 			}
-			for i := 1; i < len(model); i++ {
-				if a, b := model[i], model[i-1]; a < b {
-					panic(fmt.Sprintf("invalid cdf %v,%v < %v,%v", i, a, i-1, b))
-				} else if a == b {
-					panic(fmt.Sprintf("invalid cdf %v,%v = %v,%v", i, a, i-1, b))
-				}
+			model[i] = uint32(a + ((b - a) >> CDF32Rate))
+		}
+		if model[size] != CDF32Scale {
+			panic("cdf scale is incorrect")
+		}
+		for i := 1; i < len(model); i++ {
+			if a, b := model[i], model[i-1]; a < b {
+				panic(fmt.Sprintf("invalid cdf %v,%v < %v,%v", i, a, i-1, b))
+			} else if a == b {
+				panic(fmt.Sprintf("invalid cdf %v,%v = %v,%v", i, a, i-1, b))
 			}
-		} else {
-			for i := 1; i < size; i++ {
-				a, b := int64(model[i]), int64(mixin[i])
-				model[i] = uint32(a + ((b - a) >> CDF32Rate))
+		}
+	} else {
+		for i := 1; i < size; i++ {
+			a, b := int64(model[i]), int64(i)
+			if i > int(s) {
+				b += extra
 			}
+			model[i] = uint32(a + ((b - a) >> CDF32Rate))
 		}
+	}
+}
 
-		if depth >= length {
-			return
+func (c *CDF32) Update(s uint16) {
+	context, current := c.Context, c.First
+	length, budget := len(context), c.Budget.Nodes
+	c.Clock++
+
+	if c.Table != nil {
+		c.adapt(c.Root.Model, s)
+		h := uint64(0)
+		for depth := 0; depth < length; depth++ {
+			h = hashContext(h, context[current])
+			slot := &c.Table[h%uint64(len(c.Table))]
+			if *slot == nil {
+				c.Nodes++
+			}
+			if *slot == nil || (*slot).Check != h {
+				*slot = NewNode32(c.Size)
+				(*slot).Check = h
+			}
+			c.adapt((*slot).Model, s)
+			current = (current + 1) % length
+		}
+	} else {
+		/* make room for the longest path before walking it */
+		if budget > 0 && c.Nodes+length > budget {
+			switch c.Budget.Policy {
+			case PolicyReset:
+				c.Root.Children, c.Nodes = make(map[uint16]*Node32), 1
+			case PolicyPrune:
+				c.prune()
+			}
 		}
 
-		node := n.Children[context[current]]
-		if node == nil {
-			node = NewNode32(size)
-			n.Children[context[current]] = node
+		n := c.Root
+		for depth := 0; ; depth++ {
+			c.adapt(n.Model, s)
+			n.Used = c.Clock
+			if depth >= length {
+				break
+			}
+
+			node := n.Children[context[current]]
+			if node == nil {
+				if budget > 0 && c.Nodes >= budget {
+					break
+				}
+				node = NewNode32(c.Size)
+				n.Children[context[current]], c.Nodes = node, c.Nodes+1
+			}
+			n, current = node, (current+1)%length
 		}
-		update(node, (current+1)%length, depth+1)
 	}
 
-	update(c.Root, first, 0)
 	if length > 0 {
-		context[first], c.First = s, (first+1)%length
+		context[c.First], c.First = s, (c.First+1)%length
+	}
+}
+
+// prune drops the least recently used contexts, keeping at most half of the budget
+func (c *CDF32) prune() {
+	var used []uint64
+	var collect func(n *Node32)
+	collect = func(n *Node32) {
+		for _, child := range n.Children {
+			used = append(used, child.Used)
+			collect(child)
+		}
+	}
+	collect(c.Root)
+	keep := c.Budget.Nodes/2 - 1
+	if keep < 0 {
+		keep = 0
+	}
+	if len(used) <= keep {
+		return
+	}
+
+	/* a context is used whenever its children are, so dropping the contexts
+	used at or before the cut drops whole subtrees */
+	sort.Slice(used, func(i, j int) bool { return used[i] < used[j] })
+	cut := used[len(used)-keep-1]
+	var drop func(n *Node32)
+	drop = func(n *Node32) {
+		for s, child := range n.Children {
+			if child.Used <= cut {
+				delete(n.Children, s)
+				continue
+			}
+			drop(child)
+		}
 	}
+	drop(c.Root)
+	used = used[:0]
+	collect(c.Root)
+	c.Nodes = len(used) + 1
 }
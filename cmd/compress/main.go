@@ -0,0 +1,338 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command compress compresses and decompresses files or stdin to stdout.
+//
+// Usage:
+//
+//	compress [flags] [file ...]
+//
+// Each file is compressed to file.mrk, or decompressed with -d from file.mrk
+// to file. Without files, or with -c, the output goes to stdout; "-" or no
+// files reads stdin. Input files are never removed. With -bzip2 the files are
+// standard .bz2 files instead, which decompress with -d -bzip2 or bzip2 -d.
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pointlander/compress"
+)
+
+var extension = ".mrk"
+
+var (
+	decompress = flag.Bool("d", false, "decompress")
+	test       = flag.Bool("t", false, "test the integrity of compressed files")
+	stdout     = flag.Bool("c", false, "write to stdout")
+	force      = flag.Bool("f", false, "overwrite existing output files")
+	verify     = flag.Bool("verify", false, "decompress the output while compressing and check that it matches the input")
+	stages     = flag.Bool("stages", false, "list the registered pipeline stages")
+	seekable   = flag.Bool("seekable", false, "write a seek index for random access to the decompressed data")
+	dict       = flag.String("dict", "", "preset dictionary built by the dictionary command; needed again to decompress")
+	level      = flag.Int("bzip2", 0, "write bzip2 files with blocks of this many 100K bytes, 1 to 9, and read them with -d")
+
+	spec      = flag.String("spec", "", "pipeline spec such as bbwt|mtf-rle|cdf16(depth=2); overrides -bwt, -mtf, -model, -bits, -depth, -order, -range and -sse")
+	transform = flag.String("bwt", "bbwt", "Burrows-Wheeler transform: bbwt (bijective), bwt (suffix array) or none")
+	mapping   = flag.String("mtf", "mtf-rle", "move to front variant: mtf, mtf-rle or identity")
+	model     = flag.String("model", "adaptive", "model: adaptive, adaptive-predictive, adaptive-bit, adaptive-predictive-bit, "+
+		"filtered-adaptive-bit, filtered-adaptive-predictive-bit, mixing-bit, ctw-bit, fenwick, rans, tans, huffman, ppm, ppm-star or cdf")
+	bits    = flag.Int("bits", 16, "arithmetic coder precision: 16 or 32")
+	depth   = flag.Int("depth", 2, "context depth of the cdf model")
+	order   = flag.Int("order", 4, "maximum context order of the ppm models")
+	ranged  = flag.Bool("range", false, "code with the byte oriented range coder instead of the bitwise arithmetic coder")
+	refined = flag.Bool("sse", false, "refine the probabilities of the bit models with secondary estimation")
+	block   = flag.String("block", "1M", "block size in bytes, with an optional K, M or G suffix")
+	workers = flag.Int("workers", 0, "blocks compressed in parallel; the number of CPUs if zero")
+)
+
+// pipelineSpec builds the pipeline spec from the flags
+func pipelineSpec() (string, error) {
+	if *spec != "" {
+		return *spec, nil
+	}
+
+	entropy := *model
+	switch {
+	case *bits == 16 && entropy == "cdf":
+		entropy = fmt.Sprintf("cdf16(depth=%d)", *depth)
+	case *bits == 16 && (entropy == "ppm" || entropy == "ppm-star"):
+		entropy = fmt.Sprintf("%s(order=%d)", entropy, *order)
+	case *bits == 32 && entropy == "cdf":
+		entropy = fmt.Sprintf("cdf32(depth=%d)", *depth)
+	case *bits == 32 && (entropy == "adaptive" || entropy == "adaptive-predictive" || entropy == "fenwick"):
+		entropy += "32"
+	case *bits == 32:
+		return "", fmt.Errorf("model %s has no 32 bit coder", entropy)
+	case *bits != 16:
+		return "", fmt.Errorf("bits must be 16 or 32; got %d", *bits)
+	}
+
+	if *refined {
+		if !strings.HasSuffix(entropy, "-bit") {
+			return "", fmt.Errorf("model %s is not a bit model", entropy)
+		}
+		entropy = "sse-" + entropy
+	}
+	if *ranged {
+		switch *model {
+		case "rans", "tans", "huffman":
+			return "", fmt.Errorf("model %s has its own coder and no range coder", *model)
+		}
+		entropy = "range-" + entropy
+	}
+	s := *mapping + "|" + entropy
+	if *transform != "none" {
+		s = *transform + "|" + s
+	}
+	return s, nil
+}
+
+// parseSize parses a byte count such as 64K or 1M
+func parseSize(s string) (int, error) {
+	if s == "" {
+		return 0, errors.New("empty block size")
+	}
+	shift := uint(0)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 || n > (1<<31-1)>>shift {
+		return 0, fmt.Errorf("invalid block size %q", s)
+	}
+	return n << shift, nil
+}
+
+// hashWriter hashes everything written to it
+type hashWriter struct {
+	hash.Hash
+	n int64
+}
+
+func (h *hashWriter) Write(p []byte) (int, error) {
+	h.n += int64(len(p))
+	return h.Hash.Write(p)
+}
+
+// dictionaries are the dictionaries given to readers
+var dictionaries []*compress.Dictionary
+
+func newReader(in io.Reader) (io.Reader, error) {
+	if *level > 0 {
+		return bzip2.NewReader(in), nil
+	}
+	z, err := compress.NewReader(in, dictionaries...)
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func newWriter(out io.Writer, options *compress.Options) (io.WriteCloser, error) {
+	if *level > 0 {
+		return compress.NewBzip2Writer(out, *level)
+	}
+	return compress.NewWriter(out, options), nil
+}
+
+func compressStream(in io.Reader, out io.Writer, options *compress.Options) error {
+	var (
+		check   chan error
+		pipe    *io.PipeWriter
+		written = &hashWriter{Hash: sha256.New()}
+		read    = &hashWriter{Hash: sha256.New()}
+	)
+	if *verify {
+		/* decompress the output as it is written */
+		var reader *io.PipeReader
+		reader, pipe = io.Pipe()
+		check = make(chan error, 1)
+		out = io.MultiWriter(out, pipe)
+		go func() {
+			z, err := newReader(reader)
+			if err == nil {
+				_, err = io.Copy(read, z)
+			}
+			reader.CloseWithError(err)
+			check <- err
+		}()
+		in = io.TeeReader(in, written)
+	}
+
+	z, err := newWriter(out, options)
+	if err == nil {
+		if _, err = io.Copy(z, in); err == nil {
+			err = z.Close()
+		}
+	}
+	if !*verify {
+		return err
+	}
+
+	pipe.CloseWithError(err)
+	if verr := <-check; verr != nil && verr != err {
+		err = fmt.Errorf("verify: %v", verr)
+	}
+	if err == nil && (written.n != read.n || !bytes.Equal(written.Sum(nil), read.Sum(nil))) {
+		err = errors.New("verify: decompressed output does not match the input")
+	}
+	return err
+}
+
+func decompressStream(in io.Reader, out io.Writer) error {
+	z, err := newReader(in)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, z)
+	return err
+}
+
+// process compresses or decompresses one file, "-" being stdin
+func process(name string, options *compress.Options) error {
+	in, out, output := io.Reader(os.Stdin), io.Writer(os.Stdout), ""
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+
+		switch {
+		case *test:
+			out = ioutil.Discard
+		case *stdout:
+		case *decompress:
+			if !strings.HasSuffix(name, extension) {
+				return fmt.Errorf("%s: unknown suffix, expected %s", name, extension)
+			}
+			output = strings.TrimSuffix(name, extension)
+		default:
+			output = name + extension
+		}
+	} else if *test {
+		out = ioutil.Discard
+	}
+
+	var file *os.File
+	if output != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if *force {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		var err error
+		if file, err = os.OpenFile(output, flags, 0644); err != nil {
+			return err
+		}
+		out = file
+	}
+
+	var err error
+	if *decompress || *test {
+		err = decompressStream(in, out)
+	} else {
+		err = compressStream(in, out, options)
+	}
+	if file != nil {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(output)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, strings.TrimPrefix(err.Error(), "compress: "))
+	}
+	return nil
+}
+
+// report prints err, prefixed with the name of the command unless the package already did
+func report(err error) {
+	if message := err.Error(); strings.HasPrefix(message, "compress: ") {
+		fmt.Fprintln(os.Stderr, message)
+	} else {
+		fmt.Fprintf(os.Stderr, "compress: %s\n", message)
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: compress [flags] [file ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *stages {
+		for _, stage := range compress.Stages() {
+			fmt.Println(stage)
+		}
+		return
+	}
+
+	s, err := pipelineSpec()
+	if err == nil {
+		_, err = compress.ParseSpec(s)
+	}
+	if err != nil {
+		report(err)
+		os.Exit(2)
+	}
+	if *level != 0 {
+		if *level < 1 || *level > 9 {
+			fmt.Fprintf(os.Stderr, "compress: bzip2 level must be 1 to 9; got %d\n", *level)
+			os.Exit(2)
+		}
+		extension = ".bz2"
+	}
+	size, err := parseSize(*block)
+	if err != nil {
+		report(err)
+		os.Exit(2)
+	}
+	options := &compress.Options{Spec: s, BlockSize: size, Workers: *workers, Seekable: *seekable}
+	if *dict != "" {
+		data, err := ioutil.ReadFile(*dict)
+		if err != nil {
+			report(err)
+			os.Exit(2)
+		}
+		options.Dictionary = compress.NewDictionary(data)
+		dictionaries = append(dictionaries, options.Dictionary)
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	status := 0
+	for _, name := range files {
+		if err := process(name, options); err != nil {
+			report(err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
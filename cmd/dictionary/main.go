@@ -0,0 +1,58 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command dictionary builds a preset dictionary for compress -dict from
+// sample files that resemble the data to be compressed.
+//
+// Usage:
+//
+//	dictionary [-size bytes] [-o file] sample ...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pointlander/compress"
+)
+
+var (
+	size   = flag.Int("size", 16<<10, "maximum size of the dictionary in bytes")
+	output = flag.String("o", "dictionary", "output file")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: dictionary [flags] sample ...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *size <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var samples [][]byte
+	for _, name := range flag.Args() {
+		sample, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dictionary: %v\n", err)
+			os.Exit(1)
+		}
+		samples = append(samples, sample)
+	}
+
+	data := compress.BuildDictionary(samples, *size)
+	if len(data) == 0 {
+		fmt.Fprintf(os.Stderr, "dictionary: the samples have nothing in common to build a dictionary from\n")
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "dictionary: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %d bytes, id %08x\n", *output, len(data), compress.NewDictionary(data).ID)
+}
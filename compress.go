@@ -4,6 +4,8 @@
 
 package compress
 
+import "context"
+
 const (
 	BUFFER_COUNT          = 1 << 3
 	BUFFER_SIZE           = 1 << 10
@@ -12,16 +14,21 @@ const (
 	BUFFER_POOL_SIZE_MASK = BUFFER_POOL_SIZE - 1
 )
 
+// Coder8 is a stage producing or consuming bytes. Context, if set, stops the
+// goroutines of the stage and of every stage chained onto it when it is done.
 type Coder8 struct {
 	Alphabit uint16
 	Input    <-chan []uint8
 	Output   func(symbol uint8) bool
+	Context  context.Context
 }
 
+// Coder16 is a stage producing or consuming symbols of up to 16 bits
 type Coder16 struct {
 	Alphabit uint16
 	Input    <-chan []uint16
 	Output   func(symbol uint16) bool
+	Context  context.Context
 }
 
 const (
@@ -38,15 +45,66 @@ type Symbol32 struct {
 }
 
 type Model struct {
-	Scale  uint32
-	Fixed  uint32
-	Input  <-chan []Symbol
-	Output func(code uint16) Symbol
+	Scale   uint32
+	Fixed   uint32
+	Input   <-chan []Symbol
+	Output  func(code uint16) Symbol
+	Context context.Context
 }
 
 type Model32 struct {
-	Scale  uint64
-	Fixed  uint64
-	Input  <-chan []Symbol32
-	Output func(code uint32) Symbol32
+	Scale   uint64
+	Fixed   uint64
+	Input   <-chan []Symbol32
+	Output  func(code uint32) Symbol32
+	Context context.Context
+}
+
+// done returns the channel that is closed when ctx is canceled; it is nil for a nil ctx
+func done(ctx context.Context) <-chan struct{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Done()
+}
+
+// receive returns the next block of input; ok is false once input is closed or cancel is done
+func receive(input <-chan []byte, cancel <-chan struct{}) (block []byte, ok bool) {
+	select {
+	case block, ok = <-input:
+	case <-cancel:
+	}
+	return
+}
+
+// blocks returns a function that receives the next block of input for a
+// decoder, raising the context error if ctx is done first
+func blocks(ctx context.Context, input <-chan []byte) func() ([]byte, bool) {
+	cancel := done(ctx)
+	return func() ([]byte, bool) {
+		block, ok := receive(input, cancel)
+		if !ok {
+			if err := contextErr(ctx); err != nil {
+				raise(err)
+			}
+		}
+		return block, ok
+	}
+}
+
+func contextErr(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// Err returns why the pipeline feeding the model stopped early, or nil
+func (model Model) Err() error {
+	return contextErr(model.Context)
+}
+
+// Err returns why the pipeline feeding the model stopped early, or nil
+func (model Model32) Err() error {
+	return contextErr(model.Context)
 }
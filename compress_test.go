@@ -6,11 +6,22 @@ package compress
 
 import (
 	"bytes"
+	"compress/bzip2"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
 	/*"fmt"*/
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 var TESTS = [...]string{
@@ -73,6 +84,55 @@ func TestBurrowsWheeler(t *testing.T) {
 	}
 }
 
+func TestBurrowsWheelerInline(t *testing.T) {
+	in := make(chan []byte, len(TESTS))
+	for _, v := range TESTS {
+		in <- []byte(v)
+	}
+	close(in)
+	buffer := &bytes.Buffer{}
+	BurrowsWheelerInlineCoder(in).MoveToFrontRunLengthCoder().AdaptiveCoder().Code(buffer)
+
+	out, outputs := make(chan []byte, len(TESTS)), make([][]byte, len(TESTS))
+	for i, v := range TESTS {
+		outputs[i] = make([]byte, len(v))
+		out <- outputs[i]
+	}
+	close(out)
+	if err := BurrowsWheelerInlineDecoder(out).MoveToFrontRunLengthDecoder().AdaptiveDecoder().Decode(buffer); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range TESTS {
+		if string(outputs[i]) != v {
+			t.Errorf("should be '%v'; got '%v'", v, strconv.QuoteToASCII(string(outputs[i])))
+		}
+	}
+
+	/* an empty block has no sentinel position in front of it */
+	blocks := [...]string{"abc", "", "defgh"}
+	in = make(chan []byte, len(blocks))
+	for _, v := range blocks {
+		in <- []byte(v)
+	}
+	close(in)
+	buffer.Reset()
+	BurrowsWheelerInlineCoder(in).MoveToFrontRunLengthCoder().AdaptiveCoder().Code(buffer)
+	out, outputs = make(chan []byte, len(blocks)), make([][]byte, len(blocks))
+	for i, v := range blocks {
+		outputs[i] = make([]byte, len(v))
+		out <- outputs[i]
+	}
+	close(out)
+	if err := BurrowsWheelerInlineDecoder(out).MoveToFrontRunLengthDecoder().AdaptiveDecoder().Decode(buffer); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range blocks {
+		if string(outputs[i]) != v {
+			t.Errorf("should be '%v'; got '%v'", v, strconv.QuoteToASCII(string(outputs[i])))
+		}
+	}
+}
+
 const repeated = 10000
 
 func TestBijectiveBurrowsWheeler(t *testing.T) {
@@ -106,6 +166,108 @@ func TestBijectiveBurrowsWheeler(t *testing.T) {
 	close(output)
 }
 
+/* the rotations of a word in sorted order are the reference for the bijective transform */
+type rotation struct {
+	int
+	s []uint8
+}
+
+type Rotations []rotation
+
+func (r Rotations) Len() int {
+	return len(r)
+}
+
+func less(a, b rotation) bool {
+	la, lb, ia, ib := len(a.s), len(b.s), a.int, b.int
+	for {
+		if x, y := a.s[ia], b.s[ib]; x != y {
+			return x < y
+		}
+		ia, ib = ia+1, ib+1
+		if ia == la {
+			ia = 0
+		}
+		if ib == lb {
+			ib = 0
+		}
+		if ia == a.int && ib == b.int {
+			break
+		}
+	}
+	return false
+}
+
+func (r Rotations) Less(i, j int) bool {
+	return less(r[i], r[j])
+}
+
+func (r Rotations) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func TestSuffixArray(t *testing.T) {
+	bijective := func(input []byte) []byte {
+		var lyndon Lyndon
+		lyndon.Factor(append([]byte(nil), input...))
+		rotations := Rotations{}
+		for _, word := range lyndon.Words {
+			for i := range word {
+				rotations = append(rotations, rotation{i, word})
+			}
+		}
+		sort.Sort(rotations)
+		output := make([]byte, len(input))
+		for i, r := range rotations {
+			if r.int == 0 {
+				r.int = len(r.s)
+			}
+			output[i] = r.s[r.int-1]
+		}
+		return output
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for c := 0; c < 2000; c++ {
+		input, alphabet := make([]byte, 1+random.Intn(300)), 1+random.Intn(4)
+		if c%7 == 0 {
+			alphabet = 256
+		}
+		for i := range input {
+			input[i] = byte(random.Intn(alphabet))
+		}
+		if c%13 == 0 {
+			for period, i := 1+random.Intn(5), 0; i < len(input); i++ {
+				if i >= period {
+					input[i] = input[i-period]
+				}
+			}
+		}
+
+		sa := SuffixArray(input)
+		for i := 1; i < len(sa); i++ {
+			if bytes.Compare(input[sa[i-1]:], input[sa[i]:]) >= 0 {
+				t.Fatalf("suffix array of %q is not sorted at %v", input, i)
+			}
+		}
+
+		bw, sentinel := BuildSuffixTree(append([]byte(nil), input...)).BurrowsWheelerCoder()
+		expected := []byte{}
+		for b := range bw {
+			expected = append(expected, b)
+		}
+		output := make([]byte, len(input))
+		if s := burrowsWheeler(input, output); !bytes.Equal(output, expected) || s != <-sentinel {
+			t.Fatalf("burrows wheeler transform of %q is wrong", input)
+		}
+
+		bijectiveBurrowsWheeler(input, output)
+		if expected := bijective(input); !bytes.Equal(output, expected) {
+			t.Fatalf("bijective burrows wheeler transform of %q should be %q; got %q", input, expected, output)
+		}
+	}
+}
+
 func TestMoveToFront(t *testing.T) {
 	test := func(buffer []byte) {
 		input, output := make(chan []byte), make(chan []byte, 1)
@@ -360,6 +522,151 @@ func TestFiltered(t *testing.T) {
 	}
 }
 
+func TestAlphabet(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	test := func(size, length int, coder func(Coder16) Encoder, decoder func(Coder16) Decoder) {
+		/* a skewed distribution over the whole alphabet */
+		input := make([]uint16, length)
+		for i := range input {
+			s := random.Intn(size)
+			if random.Intn(2) == 0 {
+				s = random.Intn(1 + size/16)
+			}
+			input[i] = uint16(s)
+		}
+		symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+		symbols <- append([]uint16(nil), input...)
+		close(symbols)
+		coder(Coder16{Alphabit: uint16(size), Input: symbols}).Code(buffer)
+
+		out, i := make([]uint16, length), 0
+		output := func(symbol uint16) bool {
+			out[i] = symbol
+			i++
+			return i >= len(out)
+		}
+		if err := decoder(Coder16{Alphabit: uint16(size), Output: output}).Decode(buffer); err != nil {
+			t.Fatal(err)
+		}
+		for i := range input {
+			if out[i] != input[i] {
+				t.Fatalf("alphabet of %d symbols: symbol %d is %d; should be %d", size, i, out[i], input[i])
+			}
+		}
+	}
+	for _, size := range [...]int{2, 3, 257, 1000, CDF16MaxSize} {
+		test(size, 3000, func(c Coder16) Encoder {
+			return c.FilteredAdaptiveCoder(NewCDF16(1, true))
+		}, func(d Coder16) Decoder {
+			return d.FilteredAdaptiveDecoder(NewCDF16(1, true))
+		})
+	}
+	for _, size := range [...]int{2, 257, 5000, CDF32MaxSize - 1} {
+		test(size, 1000, func(c Coder16) Encoder {
+			return c.FilteredAdaptiveCoder32(NewCDF32(1, true))
+		}, func(d Coder16) Decoder {
+			return d.FilteredAdaptiveDecoder32(NewCDF32(1, true))
+		})
+	}
+
+	/* the whole alphabet of a CDF32 keeps a nonzero probability */
+	cdf := NewCDF32(0, true)(CDF32MaxSize).(*CDF32)
+	for i := 0; i < 1000; i++ {
+		cdf.Update(0)
+	}
+	if model := cdf.Model(); model[CDF32MaxSize] != CDF32Scale || model[CDF32MaxSize-1] == model[CDF32MaxSize] {
+		t.Errorf("the last symbol of the alphabet has no probability")
+	}
+
+	for _, size := range [...]int{1, CDF16MaxSize + 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("an alphabet of %d symbols should panic", size)
+				}
+			}()
+			NewCDF16(1, false)(size)
+		}()
+	}
+}
+
+func TestFenwick(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:60000]
+	code := func(input []uint16, alphabit uint16, coder func(Coder16) Encoder) []byte {
+		symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+		symbols <- append([]uint16(nil), input...)
+		close(symbols)
+		coder(Coder16{Alphabit: alphabit, Input: symbols}).Code(buffer)
+		return buffer.Bytes()
+	}
+	decode := func(input []uint16, alphabit uint16, data []byte, decoder func(Coder16) Decoder) {
+		out, i := make([]uint16, len(input)), 0
+		output := func(symbol uint16) bool {
+			out[i] = symbol
+			i++
+			return i >= len(out)
+		}
+		if err := decoder(Coder16{Alphabit: alphabit, Output: output}).Decode(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		for i := range input {
+			if out[i] != input[i] {
+				t.Fatalf("alphabet of %d symbols: symbol %d is %d; should be %d", alphabit, i, out[i], input[i])
+			}
+		}
+	}
+
+	/* with an increment of one the Fenwick models code like the adaptive models */
+	text := make([]uint16, len(d))
+	for i, b := range d {
+		text[i] = uint16(b)
+	}
+	fenwick := code(text, 256, func(c Coder16) Encoder { return c.FenwickCoder(1) })
+	if adaptive := code(text, 256, func(c Coder16) Encoder { return c.AdaptiveCoder() }); !bytes.Equal(fenwick, adaptive) {
+		t.Errorf("fenwick output differs from adaptive output")
+	}
+	decode(text, 256, fenwick, func(d Coder16) Decoder { return d.FenwickDecoder(1) })
+	fenwick = code(text, 256, func(c Coder16) Encoder { return c.FenwickCoder32(1) })
+	if adaptive := code(text, 256, func(c Coder16) Encoder { return c.AdaptiveCoder32() }); !bytes.Equal(fenwick, adaptive) {
+		t.Errorf("fenwick32 output differs from adaptive32 output")
+	}
+	decode(text, 256, fenwick, func(d Coder16) Decoder { return d.FenwickDecoder32(1) })
+
+	/* large alphabets of Zipf distributed tokens */
+	random := rand.New(rand.NewSource(1))
+	tokens := func(size int) []uint16 {
+		zipf, input := rand.NewZipf(random, 1.1, 1, uint64(size-1)), make([]uint16, 100000)
+		for i := range input {
+			input[i] = uint16(zipf.Uint64())
+		}
+		return input
+	}
+	input := tokens(FenwickMaxSize)
+	decode(input, FenwickMaxSize, code(input, FenwickMaxSize, func(c Coder16) Encoder { return c.FenwickCoder(16) }),
+		func(d Coder16) Decoder { return d.FenwickDecoder(16) })
+	input = tokens(65535)
+	data := code(input, 65535, func(c Coder16) Encoder { return c.FenwickCoder32(32) })
+	decode(input, 65535, data, func(d Coder16) Decoder { return d.FenwickDecoder32(32) })
+	if slow := code(input, 65535, func(c Coder16) Encoder { return c.FenwickCoder32(1) }); len(data) >= len(slow) {
+		t.Errorf("an increment of 32 should adapt faster: %d >= %d bytes", len(data), len(slow))
+	}
+
+	for _, increment := range [...]int{0, MAX_SCALE16 - 2*FenwickMaxSize + 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("an increment of %d should panic", increment)
+				}
+			}()
+			Coder16{Alphabit: FenwickMaxSize}.FenwickDecoder(increment)
+		}()
+	}
+}
+
 func TestFiltered32(t *testing.T) {
 	testFiltered := func(test string, depth int) {
 		t.Log(test, len(test))
@@ -410,3 +717,1434 @@ func TestMark1(t *testing.T) {
 		}
 	}
 }
+
+func TestFrame(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	tests := append(TESTS[:], "", string(d[:16384]))
+
+	for _, pipeline := range [...]Pipeline{Mark1Pipeline16, Mark1Pipeline1, BurrowsWheelerPipeline16} {
+		for _, v := range tests {
+			buffer := &bytes.Buffer{}
+			if err := Mark1CompressFrame([]byte(v), buffer, pipeline); err != nil {
+				t.Fatal(err)
+			}
+			header, err := ReadFrameHeader(bytes.NewReader(buffer.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Pipeline != pipeline || header.Length != uint64(len(v)) {
+				t.Errorf("invalid header %+v", header)
+			}
+			output, err := Mark1DecompressFrame(buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != v {
+				t.Errorf("should be '%v'; got '%v'", v, strconv.QuoteToASCII(string(output)))
+			}
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := Mark1CompressFrame([]byte(TESTS[0]), buffer, Mark1Pipeline16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Mark1DecompressFrame(bytes.NewReader(buffer.Bytes()[:8])); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF; got %v", err)
+	}
+	corrupt := append([]byte(nil), buffer.Bytes()...)
+	corrupt[0] = 'X'
+	if _, err := Mark1DecompressFrame(bytes.NewReader(corrupt)); err != ErrMagic {
+		t.Errorf("expected invalid magic; got %v", err)
+	}
+
+	/* a short header that claims a huge number of blocks */
+	header := append([]byte(FrameMagic), FrameVersion, byte(Mark1Pipeline16), 0)
+	header = append(header, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f)
+	header = append(header, 0x80, 0x80, 0x80, 0x80, 0x80, 0x20)
+	if _, err := ReadFrameHeader(bytes.NewReader(header)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF; got %v", err)
+	}
+
+	/* block sizes that are empty, too large or that add up past 2^64 */
+	for _, blocks := range [...][]FrameBlock{
+		{{Length: 1, Compressed: 0}},
+		{{Length: 1, Compressed: 1 << 62}},
+		{{Length: MaxBlockSize + 1, Compressed: 1}},
+		{{Length: 1, Compressed: 1<<63 + 1}, {Length: 1, Compressed: 1<<63 + 1}},
+	} {
+		length := uint64(0)
+		for _, block := range blocks {
+			length += block.Length
+		}
+		buffer := &bytes.Buffer{}
+		h := &FrameHeader{Version: FrameVersion, Pipeline: Mark1Pipeline16, Length: length, Blocks: blocks}
+		if _, err := h.WriteTo(buffer); err != nil {
+			t.Fatal(err)
+		}
+		buffer.WriteString("data")
+		if _, err := Mark1DecompressFrame(buffer); err != ErrHeader {
+			t.Errorf("blocks %+v: expected invalid header; got %v", blocks, err)
+		}
+	}
+	/* a block larger than what follows the header is not allocated up front */
+	buffer.Reset()
+	h := &FrameHeader{Version: FrameVersion, Pipeline: Mark1Pipeline16, Length: MaxBlockSize,
+		Blocks: []FrameBlock{{Length: MaxBlockSize, Compressed: 2 * MaxBlockSize}}}
+	if _, err := h.WriteTo(buffer); err != nil {
+		t.Fatal(err)
+	}
+	buffer.WriteString("data")
+	if _, err := Mark1DecompressFrame(buffer); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF; got %v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d = d[:40000]
+
+	for _, pipeline := range [...]Pipeline{Mark1Pipeline16, Mark1Pipeline1, BurrowsWheelerPipeline16} {
+		buffer := &bytes.Buffer{}
+		writer := NewWriter(buffer, &Options{Pipeline: pipeline, BlockSize: 4096})
+		for i := 0; i < len(d); i += 1000 {
+			end := i + 1000
+			if end > len(d) {
+				end = len(d)
+			}
+			if _, err := writer.Write(d[i:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(d); err != ErrClosed {
+			t.Errorf("expected closed writer; got %v", err)
+		}
+
+		reader, err := NewReader(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(output, d) {
+			t.Errorf("stream decompression failed")
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := NewWriter(buffer, nil).Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewReader(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := ioutil.ReadAll(reader); err != nil || len(output) != 0 {
+		t.Errorf("expected empty stream; got %v %v", len(output), err)
+	}
+	if _, err := NewReader(&bytes.Buffer{}); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF; got %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d = d[:16384]
+
+	buffer := &bytes.Buffer{}
+	Mark1Compress16(d, buffer)
+	output := make([]byte, len(d))
+	if err := Mark1Decompress16(bytes.NewReader(buffer.Bytes()), output); err != nil || !bytes.Equal(output, d) {
+		t.Fatalf("decompression failed: %v", err)
+	}
+	truncated := buffer.Bytes()[:buffer.Len()/2]
+	if err := Mark1Decompress16(bytes.NewReader(truncated), output); err != ErrTruncated {
+		t.Errorf("expected truncated stream; got %v", err)
+	}
+
+	symbols := make(chan []uint16, 1)
+	symbols <- []uint16{1, 2, 300, 4}
+	close(symbols)
+	buffer.Reset()
+	Coder16{Alphabit: 512, Input: symbols}.AdaptiveCoder().Code(buffer)
+	channel := make(chan []byte, 1)
+	channel <- make([]byte, 4)
+	close(channel)
+	decoder := BijectiveBurrowsWheelerDecoder(channel).MoveToFrontDecoder()
+	decoder.Alphabit = 512
+	err = decoder.AdaptiveDecoder().Decode(buffer)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected corrupt stream; got %v", err)
+	}
+	if e, ok := err.(*CorruptInputError); !ok || e.Symbol != 300 {
+		t.Errorf("expected corrupt symbol 300; got %v", err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:40000]
+
+	for _, spec := range [...]string{"bbwt|mtf-rle|adaptive", "identity|adaptive-predictive", "bbwt|mtf|adaptive-bit",
+		"identity|filtered-adaptive-predictive-bit", "bbwt|mtf|cdf16(depth=2)", "bbwt|mtf-rle|fenwick",
+		"bbwt|mtf-rle|adaptive32", "identity|cdf32(depth=2)", "bbwt|mtf-rle|fenwick32(increment=8)"} {
+		arithmetic, err := ParseSpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := strings.LastIndex(spec, "|") + 1
+		codec, err := ParseSpec(spec[:i] + "range-" + spec[i:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, buffer := &bytes.Buffer{}, &bytes.Buffer{}
+		arithmetic.Compress(d, expected)
+		codec.Compress(d, buffer)
+		compressed, output := buffer.Bytes(), make([]byte, len(d))
+		if err := codec.Decompress(bytes.NewReader(compressed), output); err != nil {
+			t.Fatalf("%v: %v", codec, err)
+		}
+		if !bytes.Equal(output, d) {
+			t.Errorf("%v: decompressed output differs", codec)
+		}
+		/* the range coder loses a little precision to the division of the range */
+		if len(compressed) > expected.Len()+expected.Len()/50+8 {
+			t.Errorf("%v: %d bytes is much larger than the %d bytes of %v", codec, len(compressed), expected.Len(), arithmetic)
+		}
+		if err := codec.Decompress(bytes.NewReader(compressed[:len(compressed)/2]), output); err != ErrTruncated {
+			t.Errorf("%v: expected truncated stream; got %v", codec, err)
+		}
+	}
+
+	/* symbols at the top of the range push carries through long runs of 0xff */
+	input := make([]uint16, 100000)
+	for i := range input {
+		input[i] = 255
+		if i%5000 == 0 {
+			input[i] = uint16(i / 5000)
+		}
+	}
+	for _, fixed := range [...]bool{false, true} {
+		symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+		symbols <- append([]uint16(nil), input...)
+		close(symbols)
+		if fixed {
+			Range(Coder16{Alphabit: 256, Input: symbols}.FilteredAdaptiveCoder(NewCDF16(0, false))).Code(buffer)
+		} else {
+			Range(Coder16{Alphabit: 256, Input: symbols}.AdaptiveCoder()).Code(buffer)
+		}
+		out, i := make([]uint16, len(input)), 0
+		output := func(symbol uint16) bool {
+			out[i] = symbol
+			i++
+			return i >= len(out)
+		}
+		decoder := Coder16{Alphabit: 256, Output: output}
+		if fixed {
+			err = Range(decoder.FilteredAdaptiveDecoder(NewCDF16(0, false))).Decode(buffer)
+		} else {
+			err = Range(decoder.AdaptiveDecoder()).Decode(buffer)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range input {
+			if out[i] != input[i] {
+				t.Fatalf("symbol %d is %d; should be %d", i, out[i], input[i])
+			}
+		}
+	}
+}
+
+func TestANS(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:50000]
+	random := rand.New(rand.NewSource(1))
+	zipf, wide := rand.NewZipf(random, 1.2, 1, 40000), make([]uint16, 30000)
+	for i := range wide {
+		wide[i] = uint16(zipf.Uint64())
+	}
+	text := make([]uint16, len(d))
+	for i, b := range d {
+		text[i] = uint16(b)
+	}
+
+	test := func(input []uint16, alphabit uint16, ans ANS, table bool) []byte {
+		symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+		symbols <- append([]uint16(nil), input...)
+		close(symbols)
+		coder := Coder16{Alphabit: alphabit, Input: symbols}
+		if table {
+			coder.TANSCoder(ans).Code(buffer)
+		} else {
+			coder.RANSCoder(ans).Code(buffer)
+		}
+		compressed := append([]byte(nil), buffer.Bytes()...)
+
+		out, i := make([]uint16, len(input)), 0
+		output := func(symbol uint16) bool {
+			out[i] = symbol
+			i++
+			return i >= len(out)
+		}
+		decoder := Coder16{Alphabit: alphabit, Output: output}
+		if table {
+			err = decoder.TANSDecoder(ans).Decode(buffer)
+		} else {
+			err = decoder.RANSDecoder(ans).Decode(buffer)
+		}
+		if err != nil {
+			t.Fatalf("%+v %v: %v", ans, table, err)
+		}
+		for i := range input {
+			if out[i] != input[i] {
+				t.Fatalf("%+v %v: symbol %d is %d; should be %d", ans, table, i, out[i], input[i])
+			}
+		}
+		return compressed
+	}
+
+	/* order 0 adaptive arithmetic coding is the reference for the ratio */
+	symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+	symbols <- append([]uint16(nil), text...)
+	close(symbols)
+	Coder16{Alphabit: 256, Input: symbols}.AdaptiveCoder().Code(buffer)
+	reference := buffer.Len()
+	for _, table := range [...]bool{false, true} {
+		for _, ans := range [...]ANS{{0, 12, 1}, {0, 12, 4}, {0, 8, 3}, {0, 16, 2}, {4096, 11, 4}, {1000, 10, 1}} {
+			/* small totals and small blocks cost a little precision and a lot of tables */
+			compressed := test(text, 256, ans, table)
+			if ans.Log >= 11 && len(compressed) > reference+reference/20 {
+				t.Errorf("%+v %v: %d bytes is much larger than %d bytes", ans, table, len(compressed), reference)
+			}
+		}
+		test(wide, 40001, ANS{0, 12, 4}, table)
+		test(wide, 40001, ANS{5000, 8, 2}, table)
+		test([]uint16{7}, 8, ANS{0, 8, 4}, table)
+		test(make([]uint16, 10000), 2, ANS{0, 8, 1}, table)
+
+		compressed := test(text, 256, ANS{4096, 12, 4}, table)
+		decoder := Coder16{Alphabit: 256, Output: func(symbol uint16) bool { return false }}
+		if table {
+			err = decoder.TANSDecoder(ANS{4096, 12, 4}).Decode(bytes.NewReader(compressed[:len(compressed)/2]))
+		} else {
+			err = decoder.RANSDecoder(ANS{4096, 12, 4}).Decode(bytes.NewReader(compressed[:len(compressed)/2]))
+		}
+		if err != ErrTruncated {
+			t.Errorf("%v: expected truncated stream; got %v", table, err)
+		}
+		decoder.Alphabit = 100
+		if table {
+			err = decoder.TANSDecoder(ANS{4096, 12, 4}).Decode(bytes.NewReader(compressed))
+		} else {
+			err = decoder.RANSDecoder(ANS{4096, 12, 4}).Decode(bytes.NewReader(compressed))
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%v: expected corrupt stream; got %v", table, err)
+		}
+	}
+}
+
+func TestHuffman(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:50000]
+	random := rand.New(rand.NewSource(1))
+	zipf, wide := rand.NewZipf(random, 1.2, 1, 40000), make([]uint16, 30000)
+	for i := range wide {
+		wide[i] = uint16(zipf.Uint64())
+	}
+	text := make([]uint16, len(d))
+	for i, b := range d {
+		text[i] = uint16(b)
+	}
+
+	test := func(input []uint16, alphabit uint16, huffman Huffman) []byte {
+		symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+		symbols <- append([]uint16(nil), input...)
+		close(symbols)
+		Coder16{Alphabit: alphabit, Input: symbols}.HuffmanCoder(huffman).Code(buffer)
+		compressed := append([]byte(nil), buffer.Bytes()...)
+
+		out, i := make([]uint16, len(input)), 0
+		output := func(symbol uint16) bool {
+			out[i] = symbol
+			i++
+			return i >= len(out)
+		}
+		if err := (Coder16{Alphabit: alphabit, Output: output}).HuffmanDecoder(huffman).Decode(buffer); err != nil {
+			t.Fatalf("%+v: %v", huffman, err)
+		}
+		for i := range input {
+			if out[i] != input[i] {
+				t.Fatalf("%+v: symbol %d is %d; should be %d", huffman, i, out[i], input[i])
+			}
+		}
+		return compressed
+	}
+
+	symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+	symbols <- append([]uint16(nil), text...)
+	close(symbols)
+	Coder16{Alphabit: 256, Input: symbols}.AdaptiveCoder().Code(buffer)
+	reference := buffer.Len()
+	for _, huffman := range [...]Huffman{{0, 6, 50, 17}, {0, 1, 50, 17}, {0, 8, 1, 20}, {4096, 3, 100, 12},
+		{1000, 2, 7, 9}, {0, 4, 50, 4}} {
+		/* short codes and small groups, with a selector each, cost ratio */
+		compressed := test(text, 256, huffman)
+		if huffman.Length >= 12 && huffman.Group >= 50 && len(compressed) > reference+reference/20 {
+			t.Errorf("%+v: %d bytes is much larger than %d bytes", huffman, len(compressed), reference)
+		}
+	}
+	test(wide, 40001, Huffman{0, 6, 50, 17})
+	test(wide, 40001, Huffman{5000, 4, 20, 8})
+	test([]uint16{7}, 8, Huffman{0, 6, 50, 17})
+	test(make([]uint16, 10000), 2, Huffman{0, 6, 50, 17})
+
+	/* codes of weights growing like the Fibonacci numbers are limited by flattening the weights */
+	weights := make([]uint64, 40)
+	weights[0], weights[1] = 1, 1
+	for i := 2; i < len(weights); i++ {
+		weights[i] = weights[i-1] + weights[i-2]
+	}
+	kraft := 0
+	for _, length := range huffmanLengths(weights, 8) {
+		if length > 8 {
+			t.Errorf("code of length %d is longer than 8", length)
+		}
+		kraft += 1 << (8 - length)
+	}
+	if kraft != 1<<8 {
+		t.Errorf("lengths should make a complete code; sum is %v", kraft)
+	}
+
+	compressed := test(text, 256, Huffman{4096, 6, 50, 17})
+	decoder := Coder16{Alphabit: 256, Output: func(symbol uint16) bool { return false }}
+	err = decoder.HuffmanDecoder(Huffman{4096, 6, 50, 17}).Decode(bytes.NewReader(compressed[:len(compressed)/2]))
+	if err != ErrTruncated {
+		t.Errorf("expected truncated stream; got %v", err)
+	}
+	decoder.Alphabit = 100
+	if err = decoder.HuffmanDecoder(Huffman{4096, 6, 50, 17}).Decode(bytes.NewReader(compressed)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected corrupt stream; got %v", err)
+	}
+}
+
+func TestBzip2(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := rand.New(rand.NewSource(1))
+	noise := make([]byte, 250000)
+	for i := range noise {
+		noise[i] = byte(random.Intn(4))
+	}
+	inputs := [][]byte{nil, []byte("a"), []byte(TESTS[3]), noise, bytes.Repeat([]byte("abc"), 100000)}
+	for _, length := range [...]int{3, 4, 5, 255, 256, 260, 300000} {
+		inputs = append(inputs, bytes.Repeat([]byte{'x'}, length))
+	}
+
+	compress := func(input []byte, level int) []byte {
+		buffer := &bytes.Buffer{}
+		writer, err := NewBzip2Writer(buffer, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		/* split the runs across writes */
+		for len(input) > 0 {
+			n := 1 + random.Intn(1000)
+			if n > len(input) {
+				n = len(input)
+			}
+			if _, err := writer.Write(input[:n]); err != nil {
+				t.Fatal(err)
+			}
+			input = input[n:]
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	}
+	for _, input := range append(inputs, d) {
+		for _, level := range [...]int{1, 9} {
+			output, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(compress(input, level))))
+			if err != nil {
+				t.Fatalf("%d bytes at level %d: %v", len(input), level, err)
+			}
+			if !bytes.Equal(output, input) {
+				t.Fatalf("%d bytes at level %d should round trip", len(input), level)
+			}
+		}
+	}
+	if size := len(compress(d, 9)); size > len(d)*30/100 {
+		t.Errorf("alice30.txt compressed to %d bytes", size)
+	}
+
+	if _, err := NewBzip2Writer(ioutil.Discard, 0); err != ErrLevel {
+		t.Errorf("level 0 should be rejected; got %v", err)
+	}
+	writer, _ := NewBzip2Writer(ioutil.Discard, 1)
+	writer.Close()
+	if _, err := writer.Write([]byte("a")); err != ErrClosed {
+		t.Errorf("expected closed writer; got %v", err)
+	}
+}
+
+// roundTrip compresses input with spec through a Writer, checks that a Reader
+// restores it and returns the size of the stream
+func roundTrip(t *testing.T, spec string, input []byte) int {
+	buffer := &bytes.Buffer{}
+	writer := NewWriter(buffer, &Options{Spec: spec})
+	if _, err := writer.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	size := buffer.Len()
+	reader, err := NewReader(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("%s: %v", spec, err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Fatalf("%s should round trip", spec)
+	}
+	return size
+}
+
+// truncated codes input with coder, checks that decoder restores it and that
+// it fails with ErrTruncated on the first half of the code
+func truncated(t *testing.T, alphabit uint16, input []uint16, coder func(Coder16) Encoder, decoder func(Coder16) Decoder) {
+	symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+	symbols <- append([]uint16(nil), input...)
+	close(symbols)
+	coder(Coder16{Alphabit: alphabit, Input: symbols}).Code(buffer)
+	data := buffer.Bytes()
+	out, i := make([]uint16, len(input)), 0
+	output := func(symbol uint16) bool {
+		out[i] = symbol
+		i++
+		return i >= len(out)
+	}
+	if err := decoder(Coder16{Alphabit: alphabit, Output: output}).Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	for i := range input {
+		if out[i] != input[i] {
+			t.Fatalf("symbol %d is %d; should be %d", i, out[i], input[i])
+		}
+	}
+	i = 0
+	if err := decoder(Coder16{Alphabit: alphabit, Output: output}).Decode(bytes.NewReader(data[:len(data)/2])); err != ErrTruncated {
+		t.Errorf("truncated input should fail with ErrTruncated; got %v", err)
+	}
+}
+
+func TestMixing(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:60000]
+
+	/* the mixer should beat the single predictor it generalizes */
+	mixing, filtered := roundTrip(t, "identity|mixing-bit", d), roundTrip(t, "identity|filtered-adaptive-predictive-bit", d)
+	if mixing >= filtered*3/4 {
+		t.Errorf("mixing compressed to %d bytes; filtered adaptive predictive to %d", mixing, filtered)
+	}
+	/* long matches and an alphabet of 257 symbols */
+	roundTrip(t, "identity|mixing-bit", bytes.Repeat(d[:5000], 4))
+	roundTrip(t, "bbwt|mtf-rle|mixing-bit", d)
+	roundTrip(t, "bbwt|mtf-rle|range-mixing-bit", d)
+
+	truncated(t, 4, []uint16{1, 2, 3, 1, 2, 3, 1, 2, 3, 1, 2, 3}, func(coder Coder16) Encoder {
+		return coder.MixingBitCoder()
+	}, func(decoder Coder16) Decoder {
+		return decoder.MixingBitDecoder()
+	})
+}
+
+func TestSSE(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:60000]
+
+	/* secondary estimation should pay off on the skewed bits after move to front */
+	for _, model := range [...]string{"adaptive-bit", "filtered-adaptive-bit", "filtered-adaptive-predictive-bit"} {
+		primary, refined := roundTrip(t, "bbwt|mtf|"+model, d), roundTrip(t, "bbwt|mtf|sse-"+model, d)
+		if refined >= primary*95/100 {
+			t.Errorf("sse-%s compressed to %d bytes; %s to %d", model, refined, model, primary)
+		}
+	}
+	for _, spec := range [...]string{"bbwt|mtf-rle|sse-adaptive-predictive-bit(order=1)",
+		"bbwt|mtf-rle|sse-mixing-bit(order=2,rate=1)", "identity|range-sse-filtered-adaptive-bit(rate=15)"} {
+		roundTrip(t, spec, d)
+	}
+
+	for _, spec := range [...]string{"mtf|sse-adaptive-bit(order=3)", "mtf|sse-adaptive-bit(rate=0)",
+		"mtf|sse-adaptive-bit(rate=16)", "mtf|sse-adaptive"} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Errorf("%q should not parse", spec)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("an SSE order of %d should panic", SSE_MAX_ORDER+1)
+		}
+	}()
+	NewSSEBitPredictor(NewAdaptiveBitPredictor(), 256, SSE_MAX_ORDER+1, SSE_RATE)
+}
+
+func TestCTW(t *testing.T) {
+	for x := uint32(1); x <= 1<<16; x++ {
+		if log, expected := ctwLog(x), math.Log2(float64(x))*256; math.Abs(float64(log)-expected) > 1.01 {
+			t.Fatalf("log2(%d) is %d/256; should be %f/256", x, log, expected)
+		}
+	}
+
+	/* on an order 2 Markov source of bits the weighted trees of depth 6 should be close to the source */
+	random, ctw, cost, ideal := rand.New(rand.NewSource(1)), NewCTWBitPredictor(6), 0.0, 0.0
+	ones, history := [4]float64{.1, .8, .3, .95}, 0
+	const n = 50000
+	for i := 0; i < n; i++ {
+		b, p := uint16(0), ones[history&3]
+		if random.Float64() < p {
+			b = 1
+		}
+		zero, scale := ctw.Predict()
+		if b == 0 {
+			cost, ideal = cost-math.Log2(float64(zero)/float64(scale)), ideal-math.Log2(1-p)
+		} else {
+			cost, ideal = cost-math.Log2(float64(scale-zero)/float64(scale)), ideal-math.Log2(p)
+		}
+		ctw.Update(b)
+		history = history<<1 | int(b)
+	}
+	if cost > ideal*1.02 {
+		t.Errorf("ctw coded %d bits in %f bits; the source has %f", n, cost, ideal)
+	}
+
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:60000]
+
+	/* weighting every context of 16 bits should beat counting after the longest one alone */
+	weighted, counted := roundTrip(t, "bbwt|mtf-rle|ctw-bit", d), roundTrip(t, "bbwt|mtf-rle|adaptive-predictive-bit", d)
+	if weighted >= counted {
+		t.Errorf("ctw compressed to %d bytes; adaptive predictive to %d", weighted, counted)
+	}
+	for _, spec := range [...]string{"identity|ctw-bit(depth=0)", "bbwt|mtf|range-ctw-bit(depth=20)",
+		"bbwt|mtf-rle|sse-ctw-bit(order=1)"} {
+		roundTrip(t, spec, d)
+	}
+
+	truncated(t, 4, []uint16{1, 2, 3, 1, 2, 3, 1, 2, 3, 1, 2, 3}, func(coder Coder16) Encoder {
+		return coder.CTWBitCoder(4)
+	}, func(decoder Coder16) Decoder {
+		return decoder.CTWBitDecoder(4)
+	})
+
+	if _, err := ParseSpec(fmt.Sprintf("mtf|ctw-bit(depth=%d)", CTW_MAX_DEPTH+1)); err == nil {
+		t.Errorf("a depth of %d should not parse", CTW_MAX_DEPTH+1)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("a depth of %d should panic", CTW_MAX_DEPTH+1)
+		}
+	}()
+	NewCTWBitPredictor(CTW_MAX_DEPTH + 1)
+}
+
+func TestPPM(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:60000]
+
+	/* on raw text the longer contexts should pay for their escapes */
+	if ppm, adaptive := roundTrip(t, "identity|ppm", d), roundTrip(t, "identity|adaptive", d); ppm >= adaptive*2/3 {
+		t.Errorf("ppm compressed to %d bytes; adaptive to %d", ppm, adaptive)
+	}
+	for order := 0; order <= 6; order += 3 {
+		for method := PPMC; method <= PPMD; method++ {
+			roundTrip(t, fmt.Sprintf("identity|ppm(order=%d,method=%d)", order, method), d)
+		}
+	}
+	for policy := PolicyReset; policy <= PolicyFreeze; policy++ {
+		roundTrip(t, fmt.Sprintf("identity|ppm(order=5,nodes=1000,policy=%d)", policy), d)
+	}
+	roundTrip(t, "bbwt|mtf-rle|range-ppm(order=2)", d)
+
+	/* a large alphabet, where most symbols are first coded out of the whole alphabet */
+	random := rand.New(rand.NewSource(1))
+	zipf, input := rand.NewZipf(random, 1.1, 1, PPMMaxSize-1), make([]uint16, 50000)
+	for i := range input {
+		input[i] = uint16(zipf.Uint64())
+	}
+	config := PPM{Order: 2, Method: PPMD}
+	truncated(t, PPMMaxSize, input, func(coder Coder16) Encoder {
+		return coder.PPMCoder(config)
+	}, func(decoder Coder16) Decoder {
+		return decoder.PPMDecoder(config)
+	})
+
+	for _, spec := range [...]string{"identity|ppm(order=17)", "identity|ppm(method=2)", "identity|ppm(hashed=1)",
+		"identity|ppm(policy=3)"} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Errorf("%q should not parse", spec)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("an alphabet of %d symbols should panic", PPMMaxSize+1)
+		}
+	}()
+	Coder16{Alphabit: PPMMaxSize + 1}.PPMDecoder(config)
+}
+
+func TestPPMStar(t *testing.T) {
+	/* the suffix tree should find the longest suffix seen before, and whether it was always followed by
+	the same symbol, across its rebuilds */
+	random := rand.New(rand.NewSource(1))
+	tree := newStarTree(PPM_STAR_MIN_WINDOW)
+	equal := func(a, b []uint16) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for n := 0; n < 2000; n++ {
+		tree.add(uint16(random.Intn(3)))
+		text, length, followers := tree.text, 0, make(map[uint16]bool)
+		for l := len(text) - 1; l > 0 && length == 0; l-- {
+			for j := 0; j+l < len(text); j++ {
+				if equal(text[j:j+l], text[len(text)-l:]) {
+					length = l
+					break
+				}
+			}
+		}
+		for j := 0; j+length < len(text); j++ {
+			if equal(text[j:j+length], text[len(text)-length:]) {
+				followers[text[j+length]] = true
+			}
+		}
+		if s, l, deterministic := tree.predict(); l != length || deterministic != (len(followers) == 1) ||
+			deterministic && !followers[s] {
+			t.Fatalf("symbol %d: predicted %d after %d symbols, %v; should be %v after %d", n, s, l,
+				deterministic, followers, length)
+		}
+	}
+
+	/* snapshots of a configuration file that change a few lines at a time */
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("key%d = %d\n", i, random.Intn(1000))
+	}
+	snapshots := &bytes.Buffer{}
+	for snapshot := 0; snapshot < 30; snapshot++ {
+		for i := 0; i < 3; i++ {
+			j := random.Intn(len(lines))
+			lines[j] = fmt.Sprintf("key%d = %d\n", j, random.Intn(100000))
+		}
+		fmt.Fprintf(snapshots, "# snapshot %d\n%s", snapshot, strings.Join(lines, ""))
+	}
+	d := snapshots.Bytes()
+	if star, ppm := roundTrip(t, "identity|ppm-star", d), roundTrip(t, "identity|ppm", d); star >= ppm/4 {
+		t.Errorf("ppm-star compressed the snapshots to %d bytes; ppm to %d", star, ppm)
+	}
+	roundTrip(t, "identity|ppm-star(window=256,order=0,method=0)", d)
+	roundTrip(t, "identity|ppm-star(window=1024,nodes=1000,policy=1)", d)
+	roundTrip(t, "bbwt|mtf-rle|range-ppm-star(order=2)", d)
+
+	input := make([]uint16, 20000)
+	for i := range input {
+		if i < 1000 || random.Intn(10) == 0 {
+			input[i] = uint16(random.Intn(PPMMaxSize))
+		} else {
+			input[i] = input[i-1000]
+		}
+	}
+	config := PPMStar{PPM: PPM{Order: 2, Method: PPMD}, Window: 4096}
+	truncated(t, PPMMaxSize, input, func(coder Coder16) Encoder {
+		return coder.PPMStarCoder(config)
+	}, func(decoder Coder16) Decoder {
+		return decoder.PPMStarDecoder(config)
+	})
+
+	for _, spec := range [...]string{"identity|ppm-star(window=255)", "identity|ppm-star(window=4194305)",
+		"identity|ppm-star(order=17)", "identity|ppm-star(hashed=1)"} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Errorf("%q should not parse", spec)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	input := make(chan []byte)
+	model := BijectiveBurrowsWheelerCoderContext(ctx, input).MoveToFrontRunLengthCoder().AdaptiveCoder()
+	input <- []byte(TESTS[0])
+	input <- []byte(TESTS[1])
+	cancel()
+	for range model.Input {
+	}
+	if err := model.Err(); err != context.Canceled {
+		t.Errorf("expected canceled pipeline; got %v", err)
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%v goroutines leaked", n-goroutines)
+	}
+
+	buffer := &bytes.Buffer{}
+	Mark1Compress16([]byte(TESTS[0]), buffer)
+	output := make(chan []byte)
+	err := BijectiveBurrowsWheelerDecoderContext(ctx, output).MoveToFrontRunLengthDecoder().AdaptiveDecoder().Decode(buffer)
+	if err != context.Canceled {
+		t.Errorf("expected canceled decoder; got %v", err)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d = d[:40000]
+
+	buffer := &bytes.Buffer{}
+	if err := Mark1CompressFrameOptions(d, buffer, &Options{BlockSize: 4096, Workers: 4}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadFrameHeader(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header.Blocks) != 10 || header.Blocks[9].Length != 40000-9*4096 {
+		t.Errorf("invalid blocks %+v", header.Blocks)
+	}
+	output, err := Mark1DecompressFrame(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, d) {
+		t.Errorf("parallel decompression failed")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d = d[:16384]
+
+	buffer := &bytes.Buffer{}
+	if err := Mark1CompressFrameOptions(d, buffer, &Options{BlockSize: 4096}); err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadFrameHeader(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != FrameVersion || header.Blocks[0].Checksum == 0 {
+		t.Fatalf("invalid header %+v", header)
+	}
+
+	frame, offset := buffer.Bytes(), buffer.Len()
+	for _, block := range header.Blocks {
+		offset -= int(block.Compressed)
+	}
+	random := rand.New(rand.NewSource(1))
+	for c := 0; c < 64; c++ {
+		position, corrupt := offset+random.Intn(len(frame)-offset), append([]byte(nil), frame...)
+		corrupt[position] ^= 1 << uint(random.Intn(8))
+		block, end := 0, offset+int(header.Blocks[0].Compressed)
+		for position >= end {
+			block++
+			end += int(header.Blocks[block].Compressed)
+		}
+
+		_, err := Mark1DecompressFrame(bytes.NewReader(corrupt))
+		var e *BlockError
+		if !errors.As(err, &e) || e.Block != block || errors.Is(err, ErrTruncated) ||
+			!errors.Is(err, ErrCorrupt) && !errors.Is(err, ErrChecksum) {
+			t.Errorf("flipped bit at %v should fail block %v as corrupt; got %v", position, block, err)
+		}
+	}
+
+	/* a complete block that runs out of bits is corrupt rather than truncated */
+	start := offset
+	for _, block := range header.Blocks {
+		position, corrupt := start+int(block.Compressed)/2, append([]byte(nil), frame...)
+		start += int(block.Compressed)
+		corrupt[position] ^= 0xff
+		_, err := Mark1DecompressFrame(bytes.NewReader(corrupt))
+		if errors.Is(err, ErrTruncated) || !errors.Is(err, ErrCorrupt) && !errors.Is(err, ErrChecksum) {
+			t.Errorf("flipped byte at %v should be a corruption error; got %v", position, err)
+		} else if strings.Count(err.Error(), "compress: ") != 1 {
+			t.Errorf("error %q should have one prefix", err)
+		}
+	}
+
+	/* version 1 frames have no checksums */
+	header.Version, buffer = 1, &bytes.Buffer{}
+	if _, err := header.WriteTo(buffer); err != nil {
+		t.Fatal(err)
+	}
+	buffer.Write(frame[offset:])
+	output, err := Mark1DecompressFrame(buffer)
+	if err != nil || !bytes.Equal(output, d) {
+		t.Errorf("version 1 frame failed: %v", err)
+	}
+}
+
+func TestState(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	first, second := d[:20000], d[20000:30000]
+	symbols := func(data []byte) <-chan []uint16 {
+		input, channel := make([]uint16, len(data)), make(chan []uint16, 1)
+		for i, b := range data {
+			input[i] = uint16(b)
+		}
+		channel <- input
+		close(channel)
+		return channel
+	}
+	output := func(out []byte) func(symbol uint16) bool {
+		i := 0
+		return func(symbol uint16) bool {
+			out[i] = byte(symbol)
+			i++
+			return i >= len(out)
+		}
+	}
+
+	type state interface {
+		MarshalBinary() ([]byte, error)
+		UnmarshalBinary(data []byte) error
+	}
+	/* the model is trained on the first part, saved, and restored to decode the second part */
+	test := func(name string, trained, restored state, coder func(input <-chan []uint16) Encoder,
+		decoder func(output func(symbol uint16) bool) Decoder) {
+		coder(symbols(first)).Code(ioutil.Discard)
+		checkpoint, err := trained.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buffer := &bytes.Buffer{}
+		coder(symbols(second)).Code(buffer)
+
+		if err := restored.UnmarshalBinary(checkpoint); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if again, _ := restored.MarshalBinary(); !bytes.Equal(again, checkpoint) {
+			t.Errorf("%s: restored state differs", name)
+		}
+		out := make([]byte, len(second))
+		if err := decoder(output(out)).Decode(buffer); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out, second) {
+			t.Errorf("%s: decoding with the restored state failed", name)
+		}
+		a, _ := trained.MarshalBinary()
+		b, _ := restored.MarshalBinary()
+		if !bytes.Equal(a, b) {
+			t.Errorf("%s: the coder and decoder states differ", name)
+		}
+
+		for _, n := range [...]int{0, 3, len(checkpoint) / 2, len(checkpoint) - 1} {
+			if err := restored.UnmarshalBinary(checkpoint[:n]); err != ErrState {
+				t.Errorf("%s: expected invalid state for %v bytes; got %v", name, n, err)
+			}
+		}
+		if err := restored.UnmarshalBinary(append(checkpoint, 0)); err != ErrState {
+			t.Errorf("%s: expected invalid state for trailing data; got %v", name, err)
+		}
+	}
+
+	for _, predictive := range [...]bool{false, true} {
+		trained, restored := NewFrequencies16(256, predictive), &Frequencies16{}
+		test("frequencies16", trained, restored, func(input <-chan []uint16) Encoder {
+			return Coder16{Alphabit: 256, Input: input}.FrequencyCoder(trained)
+		}, func(output func(symbol uint16) bool) Decoder {
+			return Coder16{Alphabit: 256, Output: output}.FrequencyDecoder(restored)
+		})
+
+		trained32, restored32 := NewFrequencies32(256, predictive), &Frequencies32{}
+		test("frequencies32", trained32, restored32, func(input <-chan []uint16) Encoder {
+			return Coder16{Alphabit: 256, Input: input}.FrequencyCoder32(trained32)
+		}, func(output func(symbol uint16) bool) Decoder {
+			return Coder16{Alphabit: 256, Output: output}.FrequencyDecoder32(restored32)
+		})
+	}
+
+	cdf, restored := NewCDF16(2, false)(256).(*CDF16), &CDF16{}
+	test("cdf16", cdf, restored, func(input <-chan []uint16) Encoder {
+		return Coder16{Alphabit: 256, Input: input}.FilteredAdaptiveCoder(func(int) Filtered16 { return cdf })
+	}, func(output func(symbol uint16) bool) Decoder {
+		return Coder16{Alphabit: 256, Output: output}.FilteredAdaptiveDecoder(func(int) Filtered16 { return restored })
+	})
+
+	cdf32, restored32 := NewCDF32(2, false)(256).(*CDF32), &CDF32{}
+	test("cdf32", cdf32, restored32, func(input <-chan []uint16) Encoder {
+		return Coder16{Alphabit: 256, Input: input}.FilteredAdaptiveCoder32(func(int) Filtered32 { return cdf32 })
+	}, func(output func(symbol uint16) bool) Decoder {
+		return Coder16{Alphabit: 256, Output: output}.FilteredAdaptiveDecoder32(func(int) Filtered32 { return restored32 })
+	})
+
+	for _, budget := range [...]Budget{{Nodes: 300, Policy: PolicyPrune}, {Nodes: 300, Policy: PolicyReset}, {Nodes: 500, Hashed: true}} {
+		cdf, restored := NewBoundedCDF16(2, false, budget)(256).(*CDF16), &CDF16{}
+		test(fmt.Sprintf("cdf16 %+v", budget), cdf, restored, func(input <-chan []uint16) Encoder {
+			return Coder16{Alphabit: 256, Input: input}.FilteredAdaptiveCoder(func(int) Filtered16 { return cdf })
+		}, func(output func(symbol uint16) bool) Decoder {
+			return Coder16{Alphabit: 256, Output: output}.FilteredAdaptiveDecoder(func(int) Filtered16 { return restored })
+		})
+	}
+
+	if err := restored.UnmarshalBinary([]byte("MRKM\x02\x03")); err != ErrState {
+		t.Errorf("expected invalid state for another kind of model; got %v", err)
+	}
+
+	/* a hashed tree can not claim more used slots than the bytes that follow */
+	for _, kind := range [...]byte{stateCDF16, stateCDF32} {
+		w, scale := newStateWriter(kind), uint64(CDF16Scale/2)
+		if kind == stateCDF32 {
+			scale = CDF32Scale / 2
+		}
+		for _, x := range [...]uint64{2, 0, 0, maxStateNodes, 0, 1, 0, scale, scale, 1000, 0, 0} {
+			w.uvarint(x)
+		}
+		var err error
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if kind == stateCDF16 {
+			err = (&CDF16{}).UnmarshalBinary(*w)
+		} else {
+			err = (&CDF32{}).UnmarshalBinary(*w)
+		}
+		runtime.ReadMemStats(&after)
+		if err != ErrState {
+			t.Errorf("state %v: expected invalid state for a short hashed table; got %v", kind, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("state %v: %v bytes allocated for a short hashed table", kind, allocated)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d = d[:30000]
+	input := make([]uint16, len(d))
+	for i, b := range d {
+		input[i] = uint16(b)
+	}
+
+	/* count the nodes of the tree rather than trusting CDF16.Nodes */
+	var count func(n *Node16) int
+	count = func(n *Node16) int {
+		nodes := 1
+		for _, child := range n.Children {
+			nodes += count(child)
+		}
+		return nodes
+	}
+	test := func(budget Budget) {
+		symbols, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+		symbols <- input
+		close(symbols)
+		coder := NewBoundedCDF16(3, true, budget)(256).(*CDF16)
+		Coder16{Alphabit: 256, Input: symbols}.FilteredAdaptiveCoder(func(int) Filtered16 { return coder }).Code(buffer)
+		nodes := count(coder.Root)
+		for _, node := range coder.Table {
+			if node != nil {
+				nodes++
+			}
+		}
+		if nodes != coder.Nodes || nodes > budget.Nodes {
+			t.Errorf("%+v: %v nodes counted as %v are over the budget", budget, nodes, coder.Nodes)
+		}
+		t.Log(budget, buffer.Len(), nodes)
+
+		out, i := make([]byte, len(d)), 0
+		output := func(symbol uint16) bool {
+			out[i] = byte(symbol)
+			i++
+			return i >= len(out)
+		}
+		decoder := NewBoundedCDF16(3, true, budget)(256).(*CDF16)
+		if err := (Coder16{Alphabit: 256, Output: output}.FilteredAdaptiveDecoder(func(int) Filtered16 { return decoder }).Decode(buffer)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, d) {
+			t.Errorf("%+v: decompression failed", budget)
+		}
+
+		symbols32, buffer := make(chan []uint16, 1), &bytes.Buffer{}
+		symbols32 <- input
+		close(symbols32)
+		coder32 := NewBoundedCDF32(3, true, budget)(256).(*CDF32)
+		Coder16{Alphabit: 256, Input: symbols32}.FilteredAdaptiveCoder32(func(int) Filtered32 { return coder32 }).Code(buffer)
+		if coder32.Nodes > budget.Nodes {
+			t.Errorf("%+v: %v nodes of the 32 bit tree are over the budget", budget, coder32.Nodes)
+		}
+		i = 0
+		decoder32 := NewBoundedCDF32(3, true, budget)(256).(*CDF32)
+		if err := (Coder16{Alphabit: 256, Output: output}.FilteredAdaptiveDecoder32(func(int) Filtered32 { return decoder32 }).Decode(buffer)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, d) {
+			t.Errorf("%+v: 32 bit decompression failed", budget)
+		}
+	}
+
+	for _, policy := range [...]Policy{PolicyReset, PolicyPrune, PolicyFreeze} {
+		test(Budget{Nodes: 1000, Policy: policy})
+	}
+	test(Budget{Nodes: 1000, Hashed: true})
+}
+
+func TestSeekable(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d = d[:40000]
+
+	buffer := &bytes.Buffer{}
+	writer := NewWriter(buffer, &Options{BlockSize: 1000, Workers: 3, Seekable: true})
+	for _, part := range [...][]byte{d[:100], d[100:7777], d[7777:]} {
+		if _, err := writer.Write(part); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buffer.Bytes()
+
+	reader, err := NewSeekableReader(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.Size() != int64(len(d)) {
+		t.Fatalf("size should be %v; got %v", len(d), reader.Size())
+	}
+	random := rand.New(rand.NewSource(1))
+	for c := 0; c < 100; c++ {
+		offset, p := random.Intn(len(d)), make([]byte, random.Intn(3000))
+		n, err := reader.ReadAt(p, int64(offset))
+		if offset+len(p) > len(d) {
+			if err != io.EOF || n != len(d)-offset {
+				t.Fatalf("read at %v past the end should be short; got %v %v", offset, n, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p[:n], d[offset:offset+n]) {
+			t.Fatalf("read at %v is wrong", offset)
+		}
+	}
+	if _, err := reader.Seek(-500, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if tail, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(tail, d[len(d)-500:]) {
+		t.Errorf("reading the tail failed: %v", err)
+	}
+
+	/* readers that do not seek skip the index */
+	sequential, err := NewReader(bytes.NewReader(append(append([]byte(nil), stream...), stream...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := ioutil.ReadAll(sequential); err != nil || !bytes.Equal(output, append(append([]byte(nil), d...), d...)) {
+		t.Errorf("sequential read failed: %v", err)
+	}
+
+	corrupt := append([]byte(nil), stream...)
+	corrupt[len(corrupt)-15]++
+	if _, err := NewSeekableReader(bytes.NewReader(corrupt), int64(len(corrupt))); err != ErrIndex {
+		t.Errorf("expected invalid index; got %v", err)
+	}
+	buffer.Reset()
+	if err := Mark1CompressFrame(d, buffer, Mark1Pipeline16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSeekableReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len())); err != ErrIndex {
+		t.Errorf("expected missing index; got %v", err)
+	}
+}
+
+func TestDictionary(t *testing.T) {
+	d, err := ioutil.ReadFile("bench/alice30.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var samples [][]byte
+	for i := 0; i+1000 <= 100000; i += 1000 {
+		samples = append(samples, d[i:i+1000])
+	}
+	data := BuildDictionary(samples, 8192)
+	if len(data) == 0 || len(data) > 8192 {
+		t.Fatalf("dictionary should have up to 8192 bytes; got %v", len(data))
+	}
+	dictionary := NewDictionary(data)
+
+	/* a dictionary may also be built as a literal */
+	literal, plain := &Dictionary{ID: dictionary.ID, Data: data}, &bytes.Buffer{}
+	if err := Mark1CompressFrameOptions(d[120000:121000], plain, &Options{Spec: "identity|adaptive", Dictionary: literal}); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := Mark1DecompressFrame(plain, dictionary); err != nil || !bytes.Equal(output, d[120000:121000]) {
+		t.Errorf("dictionary literal failed: %v", err)
+	}
+
+	/* a single sample has n-grams that repeat within it */
+	if single := BuildDictionary([][]byte{d[:20000]}, 4096); len(single) != 4096 {
+		t.Errorf("dictionary of a single sample should have 4096 bytes; got %v", len(single))
+	}
+
+	/* the order 0 bit models learn too little from the dictionary to pay for the header */
+	for _, test := range [...]struct {
+		spec    string
+		smaller bool
+	}{
+		{"bbwt|mtf-rle|adaptive", true},
+		{"identity|adaptive-predictive-bit", true},
+		{"mtf|filtered-adaptive-bit", false},
+		{"identity|cdf16(depth=1)", true},
+		{"bwt|mtf-rle|adaptive32", true},
+		{"identity|cdf32(depth=1)", true},
+		{"identity|range-cdf16(depth=1)", true},
+		{"bbwt|mtf-rle|range-adaptive32", true},
+		{"identity|ppm", true},
+		{"identity|ppm-star", true},
+	} {
+		spec := test.spec
+		for _, size := range [...]int{0, 1, 200, 2000} {
+			message := d[120000 : 120000+size]
+			plain, primed := &bytes.Buffer{}, &bytes.Buffer{}
+			if err := Mark1CompressFrameOptions(message, plain, &Options{Spec: spec}); err != nil {
+				t.Fatal(err)
+			}
+			if err := Mark1CompressFrameOptions(message, primed, &Options{Spec: spec, Dictionary: dictionary}); err != nil {
+				t.Fatal(err)
+			}
+			if test.smaller && size >= 200 && primed.Len() >= plain.Len() {
+				t.Errorf("%s: %v bytes should compress better with the dictionary; got %v >= %v", spec, size, primed.Len(), plain.Len())
+			}
+
+			header, err := ReadFrameHeader(bytes.NewReader(primed.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Dictionary != dictionary.ID {
+				t.Errorf("%s: frame should record dictionary %v; got %v", spec, dictionary.ID, header.Dictionary)
+			}
+			output, err := Mark1DecompressFrame(bytes.NewReader(primed.Bytes()), NewDictionary([]byte("other")), dictionary)
+			if err != nil {
+				t.Fatalf("%s: %v", spec, err)
+			}
+			if !bytes.Equal(output, message) {
+				t.Errorf("%s: decompression with the dictionary failed for %v bytes", spec, size)
+			}
+			if _, err := Mark1DecompressFrame(bytes.NewReader(primed.Bytes())); !errors.Is(err, ErrDictionary) {
+				t.Errorf("%s: expected unknown dictionary; got %v", spec, err)
+			}
+		}
+	}
+
+	/* the stages that count their whole input before coding it cannot be primed */
+	for _, spec := range [...]string{"identity|rans", "bbwt|mtf-rle|rans(block=1024)", "identity|tans",
+		"identity|huffman", "bwt|mtf-rle|huffman"} {
+		err := Mark1CompressFrameOptions(d[:2000], &bytes.Buffer{}, &Options{Spec: spec, Dictionary: dictionary})
+		if !errors.Is(err, ErrPrime) {
+			t.Errorf("%s: expected a stage that cannot be primed; got %v", spec, err)
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	writer := NewWriter(buffer, &Options{BlockSize: 500, Workers: 2, Seekable: true, Dictionary: dictionary})
+	if _, err := writer.Write(d[:5000]); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewReader(bytes.NewReader(buffer.Bytes()), dictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(output, d[:5000]) {
+		t.Errorf("stream with a dictionary failed: %v", err)
+	}
+	seekable, err := NewSeekableReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()), dictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 700)
+	if _, err := seekable.ReadAt(p, 2222); err != nil || !bytes.Equal(p, d[2222:2922]) {
+		t.Errorf("seekable stream with a dictionary failed: %v", err)
+	}
+}
+
+func TestSpec(t *testing.T) {
+	var transforms, mappings, entropies []string
+	for _, stage := range Stages() {
+		fields := strings.Fields(stage)
+		name := strings.SplitN(fields[1], "(", 2)[0]
+		switch fields[0] {
+		case "transform":
+			transforms = append(transforms, name+"|")
+		case "mapping":
+			mappings = append(mappings, name)
+		case "entropy":
+			entropies = append(entropies, name)
+		}
+	}
+	if len(transforms) == 0 || len(mappings) == 0 || len(entropies) == 0 {
+		t.Fatalf("missing stages in %v", Stages())
+	}
+
+	input := []byte(TESTS[2])
+	for _, transform := range append(transforms, "") {
+		for _, mapping := range mappings {
+			for _, entropy := range entropies {
+				spec := transform + mapping + "|" + entropy
+				codec, err := ParseSpec(spec)
+				if err != nil {
+					if mapping == "mtf-rle" && strings.HasPrefix(entropy, "cdf") {
+						continue
+					}
+					t.Fatal(err)
+				}
+				buffer := &bytes.Buffer{}
+				codec.Compress(input, buffer)
+				output := make([]byte, len(input))
+				if err := codec.Decompress(buffer, output); err != nil {
+					t.Fatalf("%v: %v", spec, err)
+				}
+				if !bytes.Equal(input, output) {
+					t.Errorf("%v: should be '%s'; got '%s'", spec, input, output)
+				}
+			}
+		}
+	}
+
+	RegisterMapping(Mapping{Name: "test-wide", Alphabit: CDF16MaxSize + 1})
+	if _, err := ParseSpec("test-wide|cdf16"); err == nil {
+		t.Errorf("cdf16 should not accept an alphabet of %d symbols", CDF16MaxSize+1)
+	}
+	if _, err := ParseSpec("test-wide|cdf32"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseSpec("test-wide|fenwick"); err == nil {
+		t.Errorf("fenwick should not accept an alphabet of %d symbols", CDF16MaxSize+1)
+	}
+	codec, err := ParseSpec("bbwt|mtf|cdf16( depth = 3 )")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec := codec.String(); spec != "bbwt|mtf|cdf16(depth=3,hashed=0,nodes=0,policy=0)" {
+		t.Errorf("canonical spec is %v", spec)
+	}
+	for _, spec := range [...]string{"", "mtf", "a|b|c|d", "bbwt|mtf|nope", "bbwt|nope|adaptive",
+		"nope|mtf|adaptive", "mtf|cdf16(width=2)", "mtf|cdf16(depth=-1)", "mtf|cdf16(depth", "mtf|cdf16(depth)",
+		"mtf|cdf16(policy=3)", "mtf|cdf32(hashed=2)", "mtf|cdf16(depth=4000000000000)", "mtf|cdf32(depth=33)",
+		"mtf|fenwick(increment=0)", "mtf|fenwick(increment=8192)"} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Errorf("%q should not parse", spec)
+		}
+	}
+
+	for _, spec := range [...]string{"bbwt|mtf-rle|adaptive", "bwt|mtf|cdf16(depth=1,hashed=0,nodes=64,policy=1)",
+		"bbwt|mtf-rle|cdf32(depth=1,hashed=0,nodes=0,policy=0)"} {
+		buffer := &bytes.Buffer{}
+		writer := NewWriter(buffer, &Options{Spec: spec, BlockSize: 1024})
+		if _, err := writer.Write([]byte(TESTS[3])); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		header, err := ReadFrameHeader(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Spec != spec {
+			t.Errorf("header spec is %v; should be %v", header.Spec, spec)
+		}
+		reader, err := NewReader(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != TESTS[3] {
+			t.Errorf("should be '%v'; got '%s'", TESTS[3], output)
+		}
+	}
+	buffer := &bytes.Buffer{}
+	if err := Mark1CompressFrameOptions([]byte(TESTS[0]), buffer, &Options{Spec: "bbwt|mtf-rle|adaptive"}); err != nil {
+		t.Fatal(err)
+	}
+	if header, err := ReadFrameHeader(buffer); err != nil || header.Pipeline != Mark1Pipeline16 {
+		t.Errorf("spec of a numbered pipeline should use its number; got %v %v", header, err)
+	}
+	if err := NewWriter(ioutil.Discard, &Options{Spec: "nope|adaptive"}).Close(); err == nil {
+		t.Errorf("writer should reject an unknown spec")
+	}
+}
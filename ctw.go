@@ -0,0 +1,135 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+const (
+	CTW_MAX_DEPTH = 22
+	CTW_DEPTH     = 16
+	/* the counts of a node are halved once their sum passes CTW_MAX_COUNT, so that old bits are forgotten */
+	CTW_MAX_COUNT = 255
+	/* the weights are kept in the stretched domain of squash */
+	CTW_MAX_WEIGHT = 2047
+)
+
+// ctwLog returns log2(x) in 1/256ths of a bit, rounded down, by squaring, which is all integer like squash
+func ctwLog(x uint32) int32 {
+	n := uint(bits.Len32(x) - 1)
+	y, log := uint64(x)<<16>>n, int32(n)<<8
+	for bit := int32(128); bit > 0; bit >>= 1 {
+		if y = y * y >> 16; y >= 2<<16 {
+			y, log = y>>1, log|bit
+		}
+	}
+	return log
+}
+
+// ctw is context tree weighting over the previous depth bits, after Willems,
+// Shtarkov and Tjalkens. Each node of the context tree estimates the bits
+// that followed its context with the Krichevsky-Trofimov estimator, and
+// weights that estimate with the product of the weighted estimates of its two
+// children, the contexts one bit longer. Each node keeps the log of the ratio
+// of the two, scaled for squash, which weights the conditional probabilities
+// along the path from the longest context to the root.
+type ctw struct {
+	depth         int
+	counts        [][2]uint16
+	weights       []int32
+	history       uint32
+	path          []uint32
+	estimates     []int32
+	probabilities []int32
+}
+
+func checkCTW(depth int) {
+	if depth < 0 || depth > CTW_MAX_DEPTH {
+		panic(fmt.Sprintf("compress: CTW depth %d is not in 0..%d", depth, CTW_MAX_DEPTH))
+	}
+}
+
+// NewCTWBitPredictor weights the contexts of up to depth previous bits with context tree weighting, which has
+// a provable bound on its redundancy
+func NewCTWBitPredictor(depth int) BitPredictor {
+	checkCTW(depth)
+	return &ctw{depth: depth, counts: make([][2]uint16, 2<<uint(depth)), weights: make([]int32, 2<<uint(depth)),
+		path: make([]uint32, depth+1), estimates: make([]int32, depth+1), probabilities: make([]int32, depth+1)}
+}
+
+// estimate returns the Krichevsky-Trofimov estimate of a one for counts out of mixingScale
+func (c *ctw) estimate(counts *[2]uint16) int32 {
+	p := (2*int32(counts[1]) + 1) * mixingScale / (2*(int32(counts[0])+int32(counts[1])) + 2)
+	if p < 1 {
+		return 1
+	} else if p > mixingScale-1 {
+		return mixingScale - 1
+	}
+	return p
+}
+
+func (c *ctw) Predict() (uint16, uint16) {
+	node := uint32(1)
+	for d := range c.path {
+		c.path[d] = node
+		node = node<<1 | c.history>>uint(d)&1
+	}
+
+	p := c.estimate(&c.counts[c.path[c.depth]])
+	c.estimates[c.depth], c.probabilities[c.depth] = p, p
+	for d := c.depth - 1; d >= 0; d-- {
+		node := c.path[d]
+		estimate, weight := c.estimate(&c.counts[node]), squash(c.weights[node])
+		p = (weight*estimate + (mixingScale-weight)*p) / mixingScale
+		if p < 1 {
+			p = 1
+		} else if p > mixingScale-1 {
+			p = mixingScale - 1
+		}
+		c.estimates[d], c.probabilities[d] = estimate, p
+	}
+	return uint16(mixingScale - p), mixingScale
+}
+
+func (c *ctw) Update(b uint16) {
+	probability := func(p int32) uint32 {
+		if b == 0 {
+			return uint32(mixingScale - p)
+		}
+		return uint32(p)
+	}
+
+	for d, node := range c.path {
+		/* the ratio of the estimate to the weighted children grows by the ratio of their probabilities of b,
+		and ln 2 is 177/256 */
+		if d < c.depth {
+			weight := c.weights[node] + (ctwLog(probability(c.estimates[d]))-
+				ctwLog(probability(c.probabilities[d+1])))*177/256
+			if weight > CTW_MAX_WEIGHT {
+				weight = CTW_MAX_WEIGHT
+			} else if weight < -CTW_MAX_WEIGHT {
+				weight = -CTW_MAX_WEIGHT
+			}
+			c.weights[node] = weight
+		}
+
+		counts := &c.counts[node]
+		if counts[b]++; counts[0]+counts[1] > CTW_MAX_COUNT {
+			counts[0], counts[1] = counts[0]>>1, counts[1]>>1
+		}
+	}
+	c.history = c.history<<1 | uint32(b)
+}
+
+// CTWBitCoder codes the bits of each symbol with context tree weighting over the previous depth bits
+func (coder Coder16) CTWBitCoder(depth int) Model {
+	return coder.BitCoder(NewCTWBitPredictor(depth))
+}
+
+func (decoder Coder16) CTWBitDecoder(depth int) Model {
+	return decoder.BitDecoder(NewCTWBitPredictor(depth))
+}
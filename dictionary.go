@@ -0,0 +1,359 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"container/heap"
+	"errors"
+	"hash/crc32"
+	"sync"
+)
+
+var (
+	// ErrDictionary is returned for frames compressed with a dictionary that the reader was not given
+	ErrDictionary = errors.New("compress: unknown dictionary")
+	// ErrPrime is returned for a dictionary with an entropy stage that cannot be primed, such as one that
+	// counts its input before coding it
+	ErrPrime = errors.New("compress: entropy stage cannot be primed with a dictionary")
+)
+
+// Dictionary is sample data that primes the models of a codec before each
+// block is coded, so that small blocks do not pay for learning statistics.
+// The encoder and the decoder must use the same dictionary.
+type Dictionary struct {
+	// ID identifies the dictionary in frame headers; it is the CRC32C of Data and never zero
+	ID   uint32
+	Data []byte
+
+	mutex   sync.Mutex
+	primers map[string]*primer
+}
+
+// primer is the state a codec is primed with: the symbols of the dictionary
+// after the mapping stage and the arithmetic coder symbols they produce.
+// Primed is false for entropy stages that are not arithmetic coders.
+type primer struct {
+	symbols []uint16
+	coded   []Symbol
+	coded32 []Symbol32
+	primed  bool
+}
+
+// NewDictionary returns the dictionary of data
+func NewDictionary(data []byte) *Dictionary {
+	id := crc32.Checksum(data, castagnoli)
+	if id == 0 {
+		id = 1
+	}
+	return &Dictionary{ID: id, Data: data}
+}
+
+// primer returns the primer of the dictionary for codec
+func (d *Dictionary) primer(codec *Codec) *primer {
+	spec := codec.String()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if p, ok := d.primers[spec]; ok {
+		return p
+	}
+	if d.primers == nil {
+		d.primers = make(map[string]*primer)
+	}
+
+	p, data, input := &primer{}, make([]byte, len(d.Data)), make(chan []byte, 1)
+	copy(data, d.Data)
+	input <- data
+	close(input)
+	coder := Coder8{Alphabit: 256, Input: input}
+	if codec.transform != nil {
+		coder = codec.transform.Coder(nil, input, codec.args[0])
+	}
+	mapped := codec.mapping.Coder(coder, codec.args[1])
+	for block := range mapped.Input {
+		p.symbols = append(p.symbols, block...)
+	}
+
+	symbols := make(chan []uint16, 1)
+	symbols <- p.symbols
+	close(symbols)
+	mapped.Input = symbols
+	switch model := codec.entropy.Coder(mapped, codec.args[2]).(type) {
+	case Model:
+		for block := range model.Input {
+			p.coded = append(p.coded, block...)
+		}
+		p.primed = true
+	case Model32:
+		for block := range model.Input {
+			p.coded32 = append(p.coded32, block...)
+		}
+		p.primed = true
+	case Range:
+		for block := range model.Input {
+			p.coded = append(p.coded, block...)
+		}
+		p.primed = true
+	case Range32:
+		for block := range model.Input {
+			p.coded32 = append(p.coded32, block...)
+		}
+		p.primed = true
+	}
+	d.primers[spec] = p
+	return p
+}
+
+// prime makes the models of coder learn the dictionary before the input:
+// the mapped dictionary is fed to the entropy stage ahead of the input and
+// the symbols it produces are dropped before they reach the arithmetic coder
+func (p *primer) prime(mapped Coder16, entropy func(Coder16) Encoder) Encoder {
+	input, symbols, cancel := mapped.Input, make(chan []uint16, BUFFER_CHAN_SIZE), done(mapped.Context)
+	go func() {
+		defer close(symbols)
+		if len(p.symbols) > 0 {
+			select {
+			case symbols <- p.symbols:
+			case <-cancel:
+				return
+			}
+		}
+		/* the blocks are copied since the stages reuse a fixed pool of buffers */
+		for block, ok := receive16(input, cancel); ok; block, ok = receive16(input, cancel) {
+			select {
+			case symbols <- append([]uint16(nil), block...):
+			case <-cancel:
+				return
+			}
+		}
+	}()
+	mapped.Input = symbols
+
+	switch model := entropy(mapped).(type) {
+	case Model:
+		model.Input = skip(model.Input, len(p.coded), cancel)
+		return model
+	case Model32:
+		model.Input = skip32(model.Input, len(p.coded32), cancel)
+		return model
+	case Range:
+		model.Input = skip(model.Input, len(p.coded), cancel)
+		return model
+	case Range32:
+		model.Input = skip32(model.Input, len(p.coded32), cancel)
+		return model
+	default:
+		return model
+	}
+}
+
+// primeDecoder replays the coded dictionary into the entropy decoder with
+// the output of the mapping stage switched off
+func (p *primer) primeDecoder(mapped Coder16, entropy func(Coder16) Decoder) Decoder {
+	output, priming := mapped.Output, true
+	mapped.Output = func(symbol uint16) bool {
+		if priming {
+			return false
+		}
+		return output(symbol)
+	}
+
+	switch model := entropy(mapped).(type) {
+	case Model:
+		for _, s := range p.coded {
+			model.Scale = uint32(model.Output(s.Low).Scale)
+		}
+		priming = false
+		return model
+	case Model32:
+		for _, s := range p.coded32 {
+			model.Scale = uint64(model.Output(s.Low).Scale)
+		}
+		priming = false
+		return model
+	case Range:
+		for _, s := range p.coded {
+			model.Scale = uint32(model.Output(s.Low).Scale)
+		}
+		priming = false
+		return model
+	case Range32:
+		for _, s := range p.coded32 {
+			model.Scale = uint64(model.Output(s.Low).Scale)
+		}
+		priming = false
+		return model
+	default:
+		priming = false
+		return model
+	}
+}
+
+func receive16(input <-chan []uint16, cancel <-chan struct{}) (block []uint16, ok bool) {
+	select {
+	case block, ok = <-input:
+	case <-cancel:
+	}
+	return
+}
+
+// skip drops the first n symbols of input, copying the rest out of the buffer pool of the model
+func skip(input <-chan []Symbol, n int, cancel <-chan struct{}) <-chan []Symbol {
+	output := make(chan []Symbol, BUFFER_CHAN_SIZE)
+	go func() {
+		defer close(output)
+		for block := range input {
+			if n >= len(block) {
+				n -= len(block)
+				continue
+			}
+			block, n = append([]Symbol(nil), block[n:]...), 0
+			select {
+			case output <- block:
+			case <-cancel:
+				return
+			}
+		}
+	}()
+	return output
+}
+
+// skip32 drops the first n symbols of input, copying the rest out of the buffer pool of the model
+func skip32(input <-chan []Symbol32, n int, cancel <-chan struct{}) <-chan []Symbol32 {
+	output := make(chan []Symbol32, BUFFER_CHAN_SIZE)
+	go func() {
+		defer close(output)
+		for block := range input {
+			if n >= len(block) {
+				n -= len(block)
+				continue
+			}
+			block, n = append([]Symbol32(nil), block[n:]...), 0
+			select {
+			case output <- block:
+			case <-cancel:
+				return
+			}
+		}
+	}()
+	return output
+}
+
+// BuildDictionary selects up to size bytes from samples that are most
+// representative of them. Every sample is split into segments, each segment
+// is scored by how many segments share its n-grams, and the best segments are
+// picked greedily, skipping n-grams already covered. The best segments come
+// last, where adaptive models remember them best.
+func BuildDictionary(samples [][]byte, size int) []byte {
+	const (
+		gram    = 8
+		segment = 64
+	)
+
+	hash := func(b []byte) uint64 {
+		h := uint64(14695981039346656037)
+		for _, v := range b[:gram] {
+			h = (h ^ uint64(v)) * 1099511628211
+		}
+		return h
+	}
+
+	/* count the segments that contain each n-gram, so that the n-grams that
+	repeat within a single sample count too */
+	frequencies, seen, chunk := make(map[uint64]int), make(map[uint64]int), 0
+	for _, sample := range samples {
+		for i := 0; i+gram <= len(sample); i++ {
+			if i%segment == 0 {
+				chunk++
+			}
+			if h := hash(sample[i:]); seen[h] != chunk {
+				seen[h], frequencies[h] = chunk, frequencies[h]+1
+			}
+		}
+	}
+
+	score := func(data []byte) int {
+		s := 0
+		for i := 0; i+gram <= len(data); i++ {
+			if f := frequencies[hash(data[i:])]; f > 1 {
+				s += f
+			}
+		}
+		return s
+	}
+	candidates := &segments{}
+	for _, sample := range samples {
+		for i := 0; i < len(sample); i += segment {
+			end := i + segment
+			if end > len(sample) {
+				end = len(sample)
+			}
+			if s := score(sample[i:end]); s > 0 {
+				*candidates = append(*candidates, scored{data: sample[i:end], score: s})
+			}
+		}
+	}
+	heap.Init(candidates)
+
+	/* the score of a segment only drops as n-grams are covered, so a segment
+	that is still the best after being rescored is the best overall */
+	var picked [][]byte
+	total := 0
+	for candidates.Len() > 0 && total < size {
+		best := heap.Pop(candidates).(scored)
+		if s := score(best.data); s < best.score {
+			if s > 0 {
+				heap.Push(candidates, scored{data: best.data, score: s})
+			}
+			continue
+		}
+
+		data := best.data
+		if total+len(data) > size {
+			data = data[:size-total]
+		}
+		picked, total = append(picked, data), total+len(data)
+		for i := 0; i+gram <= len(data); i++ {
+			delete(frequencies, hash(data[i:]))
+		}
+	}
+
+	dictionary := make([]byte, 0, total)
+	for i := len(picked) - 1; i >= 0; i-- {
+		dictionary = append(dictionary, picked[i]...)
+	}
+	return dictionary
+}
+
+// scored is a segment of a sample and its score for BuildDictionary
+type scored struct {
+	data  []byte
+	score int
+}
+
+// segments is a max heap of scored segments
+type segments []scored
+
+func (s segments) Len() int {
+	return len(s)
+}
+
+func (s segments) Less(i, j int) bool {
+	return s[i].score > s[j].score
+}
+
+func (s segments) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s *segments) Push(x interface{}) {
+	*s = append(*s, x.(scored))
+}
+
+func (s *segments) Pop() interface{} {
+	old := *s
+	x := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return x
+}
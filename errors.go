@@ -0,0 +1,56 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTruncated is returned when a compressed stream ends before all of the symbols are decoded
+	ErrTruncated = errors.New("compress: truncated stream")
+	// ErrCorrupt matches every CorruptInputError with errors.Is
+	ErrCorrupt = errors.New("compress: corrupt stream")
+)
+
+// CorruptInputError reports a symbol that a decoder stage can not have produced from valid input
+type CorruptInputError struct {
+	Stage  string
+	Symbol uint32
+}
+
+func (e *CorruptInputError) Error() string {
+	return fmt.Sprintf("compress: corrupt input in %s: invalid symbol %d", e.Stage, e.Symbol)
+}
+
+// Is reports whether target is ErrCorrupt
+func (e *CorruptInputError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+/* decoder stages run inside of the Output callbacks of Model.Decode, so
+   they raise errors by panicking with a decodeError which Decode recovers */
+type decodeError struct {
+	err error
+}
+
+func raise(err error) {
+	panic(decodeError{err})
+}
+
+func corrupt(stage string, symbol uint32) {
+	raise(&CorruptInputError{Stage: stage, Symbol: symbol})
+}
+
+func recoverDecode(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(decodeError)
+		if !ok {
+			panic(r)
+		}
+		*err = e.err
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import "fmt"
+
+// FenwickMaxSize is the largest alphabet of the 16 bit Fenwick model, which
+// leaves most of MAX_SCALE16 for adapting
+const FenwickMaxSize = 1 << 12
+
+// fenwick is an adaptive frequency table kept in a binary indexed tree, so
+// that finding the interval of a symbol, finding the symbol of a code and
+// counting a symbol all take O(log n) for an alphabet of n symbols. Halving
+// the counts takes O(n), but it happens at most once every max/2 updates.
+type fenwick struct {
+	counts         []uint32
+	tree           []uint32
+	top            int
+	scale          uint32
+	increment, max uint32
+}
+
+func newFenwick(size int, increment, max uint32) *fenwick {
+	f := &fenwick{counts: make([]uint32, size), tree: make([]uint32, size+1), increment: increment, max: max}
+	for i := range f.counts {
+		f.counts[i] = 1
+	}
+	for f.top = 1; f.top*2 <= size; f.top *= 2 {
+	}
+	f.build()
+	return f
+}
+
+// build rebuilds the tree and the scale from the counts in O(n)
+func (f *fenwick) build() {
+	tree, size := f.tree, len(f.counts)
+	f.scale = 0
+	for i := range tree {
+		tree[i] = 0
+	}
+	for i := 1; i <= size; i++ {
+		tree[i] += f.counts[i-1]
+		f.scale += f.counts[i-1]
+		if j := i + (i & -i); j <= size {
+			tree[j] += tree[i]
+		}
+	}
+}
+
+// low returns the sum of the counts of the symbols before s
+func (f *fenwick) low(s int) uint32 {
+	sum := uint32(0)
+	for i := s; i > 0; i -= i & -i {
+		sum += f.tree[i]
+	}
+	return sum
+}
+
+// find returns the symbol whose interval contains code and the start of the interval
+func (f *fenwick) find(code uint32) (s int, low uint32, ok bool) {
+	tree, size := f.tree, len(f.counts)
+	for step := f.top; step > 0; step >>= 1 {
+		if next := s + step; next <= size && low+tree[next] <= code {
+			s, low = next, low+tree[next]
+		}
+	}
+	return s, low, s < size
+}
+
+// update counts s, halving the counts when the scale grows past max
+func (f *fenwick) update(s int) {
+	f.counts[s] += f.increment
+	f.scale += f.increment
+	if f.scale > f.max {
+		for i, count := range f.counts {
+			if count >>= 1; count == 0 {
+				count = 1
+			}
+			f.counts[i] = count
+		}
+		f.build()
+		return
+	}
+	for i := s + 1; i < len(f.tree); i += i & -i {
+		f.tree[i] += f.increment
+	}
+}
+
+// checkFenwick panics unless halving the counts after an increment brings
+// the scale of an alphabet of size symbols back under max
+func checkFenwick(size, increment, max int) {
+	if size < 1 || 2*size+1 > max {
+		panic(fmt.Sprintf("compress: Fenwick alphabet of %d symbols is not in 1..%d", size, (max-1)/2))
+	}
+	if increment < 1 || 2*size+increment > max {
+		panic(fmt.Sprintf("compress: Fenwick increment %d is not in 1..%d", increment, max-2*size))
+	}
+}
+
+// FenwickCoder is an order 0 adaptive model for large alphabets, which adds
+// increment to the count of each symbol coded. With an increment of one it
+// codes like AdaptiveCoder.
+func (coder Coder16) FenwickCoder(increment int) Model {
+	checkFenwick(int(coder.Alphabit), increment, MAX_SCALE16)
+	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
+
+	go func() {
+		defer close(out)
+		cancel := done(coder.Context)
+
+		f, buffer := newFenwick(int(coder.Alphabit), uint32(increment), MAX_SCALE16), [BUFFER_POOL_SIZE]Symbol{}
+		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
+		for input := range coder.Input {
+			for _, s := range input {
+				low := f.low(int(s))
+				current[index], index = Symbol{Scale: uint16(f.scale), Low: uint16(low), High: uint16(low + f.counts[s])}, index+1
+				if index == BUFFER_SIZE {
+					select {
+					case out <- current:
+					case <-cancel:
+						return
+					}
+					next := offset + BUFFER_SIZE
+					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
+				}
+
+				f.update(int(s))
+			}
+		}
+
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
+	}()
+
+	return Model{Input: out, Context: coder.Context}
+}
+
+func (decoder Coder16) FenwickDecoder(increment int) Model {
+	checkFenwick(int(decoder.Alphabit), increment, MAX_SCALE16)
+	f := newFenwick(int(decoder.Alphabit), uint32(increment), MAX_SCALE16)
+
+	lookup := func(code uint16) Symbol {
+		s, low, ok := f.find(uint32(code))
+		if !ok {
+			corrupt("fenwick decoder", uint32(code))
+		}
+
+		high, done := low+f.counts[s], decoder.Output(uint16(s))
+		f.update(s)
+		if done {
+			return Symbol{}
+		}
+		return Symbol{Scale: uint16(f.scale), Low: uint16(low), High: uint16(high)}
+	}
+
+	return Model{Scale: uint32(f.scale), Output: lookup, Context: decoder.Context}
+}
+
+// FenwickCoder32 is FenwickCoder for the 32 bit coder, which supports every
+// alphabet of a Coder16
+func (coder Coder16) FenwickCoder32(increment int) Model32 {
+	checkFenwick(int(coder.Alphabit), increment, MAX_SCALE32)
+	out := make(chan []Symbol32, BUFFER_CHAN_SIZE)
+
+	go func() {
+		defer close(out)
+		cancel := done(coder.Context)
+
+		f, buffer := newFenwick(int(coder.Alphabit), uint32(increment), MAX_SCALE32), [BUFFER_POOL_SIZE]Symbol32{}
+		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
+		for input := range coder.Input {
+			for _, s := range input {
+				low := f.low(int(s))
+				current[index], index = Symbol32{Scale: f.scale, Low: low, High: low + f.counts[s]}, index+1
+				if index == BUFFER_SIZE {
+					select {
+					case out <- current:
+					case <-cancel:
+						return
+					}
+					next := offset + BUFFER_SIZE
+					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
+				}
+
+				f.update(int(s))
+			}
+		}
+
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
+	}()
+
+	return Model32{Input: out, Context: coder.Context}
+}
+
+func (decoder Coder16) FenwickDecoder32(increment int) Model32 {
+	checkFenwick(int(decoder.Alphabit), increment, MAX_SCALE32)
+	f := newFenwick(int(decoder.Alphabit), uint32(increment), MAX_SCALE32)
+
+	lookup := func(code uint32) Symbol32 {
+		s, low, ok := f.find(code)
+		if !ok {
+			corrupt("fenwick decoder", code)
+		}
+
+		high, done := low+f.counts[s], decoder.Output(uint16(s))
+		f.update(s)
+		if done {
+			return Symbol32{}
+		}
+		return Symbol32{Scale: f.scale, Low: low, High: high}
+	}
+
+	return Model32{Scale: uint64(f.scale), Output: lookup, Context: decoder.Context}
+}
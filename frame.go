@@ -0,0 +1,475 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	// FrameMagic starts every compressed frame
+	FrameMagic = "MRK1"
+	// FrameVersion is the version of the frame format written by this package.
+	// Version 2 added checksums to the blocks and version 3 the dictionary ID;
+	// older frames are still read.
+	FrameVersion = 3
+)
+
+var (
+	// ErrMagic is returned when a frame does not start with FrameMagic
+	ErrMagic = errors.New("compress: invalid frame magic")
+	// ErrVersion is returned for frames written by an unknown version of the format
+	ErrVersion = errors.New("compress: unsupported frame version")
+	// ErrPipeline is returned for frames compressed with an unknown pipeline
+	ErrPipeline = errors.New("compress: unknown pipeline")
+	// ErrHeader is returned when the frame header is inconsistent
+	ErrHeader = errors.New("compress: invalid frame header")
+	// ErrChecksum is returned in a BlockError when a decompressed block does not match its checksum
+	ErrChecksum = errors.New("compress: checksum mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// BlockError reports the block of a frame that failed to decompress
+type BlockError struct {
+	Block int
+	Err   error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("compress: block %d: %s", e.Block, strings.TrimPrefix(e.Err.Error(), "compress: "))
+}
+
+// Unwrap returns the reason the block failed
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline identifies the chain of coders used to compress a frame
+type Pipeline uint8
+
+const (
+	// Mark1Pipeline16 is the pipeline of Mark1Compress16
+	Mark1Pipeline16 Pipeline = iota + 1
+	// Mark1Pipeline1 is the pipeline of Mark1Compress1
+	Mark1Pipeline1
+	// BurrowsWheelerPipeline16 is the pipeline of BurrowsWheelerCompress16
+	BurrowsWheelerPipeline16
+	// PipelineSpec marks a frame whose header spells out its pipeline spec
+	PipelineSpec Pipeline = 255
+)
+
+// maxSpec is the longest pipeline spec a frame header may hold
+const maxSpec = 1 << 10
+
+var pipelineSpecs = [...]string{
+	Mark1Pipeline16:          "bbwt|mtf-rle|adaptive",
+	Mark1Pipeline1:           "bbwt|mtf|filtered-adaptive-bit",
+	BurrowsWheelerPipeline16: "bwt|mtf-rle|adaptive",
+}
+
+// FrameBlock is the uncompressed and compressed size of one block of a frame
+// and the CRC32C of the uncompressed block, which version 1 frames lack
+type FrameBlock struct {
+	Length     uint64
+	Compressed uint64
+	Checksum   uint32
+}
+
+// FrameHeader describes a frame: the pipeline, the original length and the blocks.
+// Spec is the pipeline spec, which is only written out for PipelineSpec.
+// Dictionary is the ID of the dictionary the frame was compressed with, or zero.
+type FrameHeader struct {
+	Version    uint8
+	Pipeline   Pipeline
+	Spec       string
+	Dictionary uint32
+	Length     uint64
+	Blocks     []FrameBlock
+}
+
+// Spec returns the pipeline spec of p, or "" for PipelineSpec and unknown pipelines
+func (p Pipeline) Spec() string {
+	if int(p) < len(pipelineSpecs) {
+		return pipelineSpecs[p]
+	}
+	return ""
+}
+
+func (p Pipeline) valid() bool {
+	return p == PipelineSpec || p.Spec() != ""
+}
+
+// codec returns the codec of the pipeline of the header, primed with its
+// dictionary from dictionaries
+func (h *FrameHeader) codec(dictionaries []*Dictionary) (*Codec, error) {
+	spec := h.Spec
+	if h.Pipeline != PipelineSpec {
+		spec = h.Pipeline.Spec()
+	}
+	codec, err := ParseSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPipeline, err)
+	}
+	if h.Dictionary == 0 {
+		return codec, nil
+	}
+	for _, dictionary := range dictionaries {
+		if dictionary != nil && dictionary.ID == h.Dictionary {
+			return codec.WithDictionary(dictionary)
+		}
+	}
+	return nil, ErrDictionary
+}
+
+// WriteTo writes the frame header to w
+func (h *FrameHeader) WriteTo(w io.Writer) (int64, error) {
+	buffer := make([]byte, 0, len(FrameMagic)+2+len(h.Spec)+(3+2*len(h.Blocks))*binary.MaxVarintLen64+4*len(h.Blocks))
+	buffer = append(buffer, FrameMagic...)
+	buffer = append(buffer, h.Version, byte(h.Pipeline))
+	var scratch [binary.MaxVarintLen64]byte
+	putUvarint := func(x uint64) {
+		n := binary.PutUvarint(scratch[:], x)
+		buffer = append(buffer, scratch[:n]...)
+	}
+	if h.Pipeline == PipelineSpec {
+		putUvarint(uint64(len(h.Spec)))
+		buffer = append(buffer, h.Spec...)
+	}
+	if h.Version >= 3 {
+		putUvarint(uint64(h.Dictionary))
+	}
+	putUvarint(h.Length)
+	putUvarint(uint64(len(h.Blocks)))
+	for _, block := range h.Blocks {
+		putUvarint(block.Length)
+		putUvarint(block.Compressed)
+		if h.Version >= 2 {
+			var checksum [4]byte
+			binary.LittleEndian.PutUint32(checksum[:], block.Checksum)
+			buffer = append(buffer, checksum[:]...)
+		}
+	}
+	n, err := w.Write(buffer)
+	return int64(n), err
+}
+
+type byteReader struct {
+	io.Reader
+	buffer [1]byte
+	count  int
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	n, err := io.ReadFull(r.Reader, r.buffer[:])
+	if n == 1 {
+		r.count++
+		return r.buffer[0], nil
+	}
+	if err == io.EOF && r.count > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return 0, err
+}
+
+// ReadFrameHeader reads a frame header from r. io.EOF is returned if r is
+// empty and io.ErrUnexpectedEOF if the header is cut short.
+func ReadFrameHeader(r io.Reader) (*FrameHeader, error) {
+	in := &byteReader{Reader: r}
+	var magic [len(FrameMagic) + 2]byte
+	for i := range magic {
+		b, err := in.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		magic[i] = b
+	}
+	if string(magic[:len(IndexMagic)]) == IndexMagic {
+		return nil, errIndex
+	}
+	if string(magic[:len(FrameMagic)]) != FrameMagic {
+		return nil, ErrMagic
+	}
+	h := &FrameHeader{Version: magic[len(FrameMagic)], Pipeline: Pipeline(magic[len(FrameMagic)+1])}
+	if h.Version < 1 || h.Version > FrameVersion {
+		return nil, ErrVersion
+	}
+	if !h.Pipeline.valid() {
+		return nil, ErrPipeline
+	}
+
+	getUvarint := func() (uint64, error) {
+		x, err := binary.ReadUvarint(in)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return x, err
+	}
+	if h.Pipeline == PipelineSpec {
+		size, err := getUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 || size > maxSpec {
+			return nil, ErrHeader
+		}
+		spec := make([]byte, size)
+		if _, err := io.ReadFull(r, spec); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		h.Spec = string(spec)
+	} else {
+		h.Spec = h.Pipeline.Spec()
+	}
+	if _, err := ParseSpec(h.Spec); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPipeline, err)
+	}
+	if h.Version >= 3 {
+		dictionary, err := getUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if dictionary > 1<<32-1 {
+			return nil, ErrHeader
+		}
+		h.Dictionary = uint32(dictionary)
+	}
+
+	var err error
+	if h.Length, err = getUvarint(); err != nil {
+		return nil, err
+	}
+	count, err := getUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if count > h.Length {
+		return nil, ErrHeader
+	}
+	/* the blocks are appended as they are read, so that a header claiming
+	more blocks than it holds fails before they are allocated */
+	total, compressed := uint64(0), uint64(0)
+	for i := uint64(0); i < count; i++ {
+		var block FrameBlock
+		if block.Length, err = getUvarint(); err != nil {
+			return nil, err
+		}
+		if block.Compressed, err = getUvarint(); err != nil {
+			return nil, err
+		}
+		if h.Version >= 2 {
+			var checksum [4]byte
+			if _, err := io.ReadFull(r, checksum[:]); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			block.Checksum = binary.LittleEndian.Uint32(checksum[:])
+		}
+		if block.Length == 0 || block.Length > MaxBlockSize || block.Length > h.Length-total {
+			return nil, ErrHeader
+		}
+		if block.Compressed == 0 || block.Compressed > maxCompressed || block.Compressed > math.MaxUint64-compressed {
+			return nil, ErrHeader
+		}
+		total, compressed = total+block.Length, compressed+block.Compressed
+		h.Blocks = append(h.Blocks, block)
+	}
+	if total != h.Length {
+		return nil, ErrHeader
+	}
+	return h, nil
+}
+
+// Mark1CompressFrame compresses input with pipeline and writes it to output
+// as a self-describing frame that Mark1DecompressFrame can decode without
+// knowing the original length or pipeline
+func Mark1CompressFrame(input []byte, output io.Writer, pipeline Pipeline) error {
+	return Mark1CompressFrameOptions(input, output, &Options{Pipeline: pipeline})
+}
+
+// Mark1CompressFrameOptions splits input into blocks of options.BlockSize,
+// compresses them on options.Workers goroutines and writes them to output in
+// order as one frame
+func Mark1CompressFrameOptions(input []byte, output io.Writer, options *Options) error {
+	header, blocks, err := compressFrame(input, options)
+	if err != nil {
+		return err
+	}
+	_, err = writeFrame(output, header, blocks)
+	return err
+}
+
+// compressFrame compresses the blocks of a frame without writing them out
+func compressFrame(input []byte, options *Options) (*FrameHeader, []bytes.Buffer, error) {
+	pipeline, spec, err := options.spec()
+	if err != nil {
+		return nil, nil, err
+	}
+	header := &FrameHeader{Version: FrameVersion, Pipeline: pipeline, Spec: spec, Length: uint64(len(input))}
+	var dictionaries []*Dictionary
+	if options != nil && options.Dictionary != nil {
+		header.Dictionary, dictionaries = options.Dictionary.ID, []*Dictionary{options.Dictionary}
+	}
+	codec, err := header.codec(dictionaries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := options.blockSize()
+	header.Blocks = make([]FrameBlock, (len(input)+size-1)/size)
+	blocks := make([]bytes.Buffer, len(header.Blocks))
+	err = parallel(len(blocks), options.workers(), func(i int) error {
+		begin := i * size
+		end := begin + size
+		if end > len(input) {
+			end = len(input)
+		}
+		codec.Compress(input[begin:end], &blocks[i])
+		header.Blocks[i] = FrameBlock{
+			Length:     uint64(end - begin),
+			Compressed: uint64(blocks[i].Len()),
+			Checksum:   crc32.Checksum(input[begin:end], castagnoli),
+		}
+		if header.Blocks[i].Compressed > maxCompressed {
+			return &BlockError{Block: i, Err: ErrHeader}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, blocks, nil
+}
+
+// writeFrame writes the header and the blocks of a frame and returns the size of the header
+func writeFrame(output io.Writer, header *FrameHeader, blocks []bytes.Buffer) (int64, error) {
+	n, err := header.WriteTo(output)
+	if err != nil {
+		return n, err
+	}
+	for i := range blocks {
+		if _, err := blocks[i].WriteTo(output); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// decompressBlock decompresses block i of a frame and verifies its checksum.
+// compressed holds all of the bytes of the block, so running out of them is
+// reported as ErrCorrupt.
+func decompressBlock(codec *Codec, header *FrameHeader, i int, compressed, output []byte) error {
+	if err := codec.Decompress(bytes.NewReader(compressed), output); err != nil {
+		if errors.Is(err, ErrTruncated) {
+			err = ErrCorrupt
+		}
+		return &BlockError{Block: i, Err: err}
+	}
+	if header.Version >= 2 && crc32.Checksum(output, castagnoli) != header.Blocks[i].Checksum {
+		return &BlockError{Block: i, Err: ErrChecksum}
+	}
+	return nil
+}
+
+// Mark1DecompressFrame reads one frame written by Mark1CompressFrame from input
+// and decompresses its blocks in parallel. A block that fails to decompress or
+// does not match its checksum is reported as a BlockError. A frame compressed
+// with a dictionary needs it among dictionaries.
+func Mark1DecompressFrame(input io.Reader, dictionaries ...*Dictionary) ([]byte, error) {
+	header, err := ReadFrameHeader(input)
+	for err == errIndex {
+		/* seek indexes between frames are only of use to a SeekableReader */
+		if err = skipIndex(input); err == nil {
+			header, err = ReadFrameHeader(input)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	codec, err := header.codec(dictionaries)
+	if err != nil {
+		return nil, err
+	}
+
+	/* the blocks are read as they are dispatched, a round of workers at a
+	time, so that nothing larger than a block is allocated on the word of
+	the header alone */
+	var output []byte
+	workers := runtime.GOMAXPROCS(0)
+	for first := 0; first < len(header.Blocks); first += workers {
+		blocks := header.Blocks[first:]
+		if len(blocks) > workers {
+			blocks = blocks[:workers]
+		}
+		compressed, offsets := make([][]byte, len(blocks)), make([]int, len(blocks))
+		for i, block := range blocks {
+			data, err := ioutil.ReadAll(io.LimitReader(input, int64(block.Compressed)))
+			if err != nil {
+				return nil, err
+			}
+			if uint64(len(data)) < block.Compressed {
+				return nil, io.ErrUnexpectedEOF
+			}
+			compressed[i], offsets[i] = data, len(output)
+			output = append(output, make([]byte, block.Length)...)
+		}
+		err = parallel(len(blocks), workers, func(i int) error {
+			offset := offsets[i]
+			return decompressBlock(codec, header, first+i, compressed[i], output[offset:offset+int(blocks[i].Length)])
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if output == nil {
+		output = []byte{}
+	}
+	return output, nil
+}
+
+// parallel calls work for every index below n on at most workers goroutines
+// and returns the error of the lowest failing index
+func parallel(n, workers int, work func(i int) error) error {
+	if workers > n {
+		workers = n
+	}
+	errs, jobs, wait := make([]error, n), make(chan int), sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := range jobs {
+				errs[i] = work(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wait.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
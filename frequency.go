@@ -0,0 +1,132 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+// Frequencies16 is the state of the adaptive models: a table of symbol counts
+// for each context and their sums. The order 0 model has one table and the
+// predictive model has a table for each previous symbol.
+type Frequencies16 struct {
+	Tables  [][]uint16
+	Scales  []uint16
+	Context uint16
+}
+
+// NewFrequencies16 returns the initial state of the adaptive model for an
+// alphabet of alphabit symbols, with a table per previous symbol if predictive
+func NewFrequencies16(alphabit uint16, predictive bool) *Frequencies16 {
+	contexts := 1
+	if predictive {
+		contexts = int(alphabit)
+	}
+	f := &Frequencies16{Tables: make([][]uint16, contexts), Scales: make([]uint16, contexts)}
+	for i := range f.Tables {
+		table := make([]uint16, alphabit)
+		for j := range table {
+			table[j] = 1
+		}
+		f.Tables[i], f.Scales[i] = table, alphabit
+	}
+	return f
+}
+
+// symbol returns the interval of s in the current context
+func (f *Frequencies16) symbol(s uint16) Symbol {
+	table, low := f.Tables[f.Context], uint16(0)
+	for _, count := range table[:s] {
+		low += count
+	}
+	return Symbol{Scale: f.Scales[f.Context], Low: low, High: low + table[s]}
+}
+
+// find returns the symbol whose interval in the current context contains code
+func (f *Frequencies16) find(code uint16) (s, low, high uint16, ok bool) {
+	for i, count := range f.Tables[f.Context] {
+		if high += count; code < high {
+			return uint16(i), high - count, high, true
+		}
+	}
+	return 0, 0, high, false
+}
+
+// update counts s in the current context, halving the counts when they
+// grow past MAX_SCALE16, and moves to the context of s
+func (f *Frequencies16) update(s uint16) {
+	table, scale := f.Tables[f.Context], f.Scales[f.Context]+1
+	table[s]++
+	if scale > MAX_SCALE16 {
+		scale = 0
+		for i, count := range table {
+			if count >>= 1; count == 0 {
+				table[i], scale = 1, scale+1
+			} else {
+				table[i], scale = count, scale+count
+			}
+		}
+	}
+	f.Scales[f.Context] = scale
+	if len(f.Tables) > 1 {
+		f.Context = s
+	}
+}
+
+// Frequencies32 is Frequencies16 for the 32 bit coder
+type Frequencies32 struct {
+	Tables  [][]uint32
+	Scales  []uint32
+	Context uint16
+}
+
+// NewFrequencies32 returns the initial state of the 32 bit adaptive model
+func NewFrequencies32(alphabit uint16, predictive bool) *Frequencies32 {
+	contexts := 1
+	if predictive {
+		contexts = int(alphabit)
+	}
+	f := &Frequencies32{Tables: make([][]uint32, contexts), Scales: make([]uint32, contexts)}
+	for i := range f.Tables {
+		table := make([]uint32, alphabit)
+		for j := range table {
+			table[j] = 1
+		}
+		f.Tables[i], f.Scales[i] = table, uint32(alphabit)
+	}
+	return f
+}
+
+func (f *Frequencies32) symbol(s uint16) Symbol32 {
+	table, low := f.Tables[f.Context], uint32(0)
+	for _, count := range table[:s] {
+		low += count
+	}
+	return Symbol32{Scale: f.Scales[f.Context], Low: low, High: low + table[s]}
+}
+
+func (f *Frequencies32) find(code uint32) (s uint16, low, high uint32, ok bool) {
+	for i, count := range f.Tables[f.Context] {
+		if high += count; code < high {
+			return uint16(i), high - count, high, true
+		}
+	}
+	return 0, 0, high, false
+}
+
+func (f *Frequencies32) update(s uint16) {
+	table, scale := f.Tables[f.Context], f.Scales[f.Context]+1
+	table[s]++
+	if scale > MAX_SCALE32 {
+		scale = 0
+		for i, count := range table {
+			if count >>= 1; count == 0 {
+				table[i], scale = 1, scale+1
+			} else {
+				table[i], scale = count, scale+count
+			}
+		}
+	}
+	f.Scales[f.Context] = scale
+	if len(f.Tables) > 1 {
+		f.Context = s
+	}
+}
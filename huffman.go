@@ -0,0 +1,563 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"io"
+	"sort"
+)
+
+const (
+	HUFFMAN_MAX_LENGTH = 20
+	HUFFMAN_MAX_TABLES = 8
+	/* codes up to this long are decoded with one table lookup */
+	HUFFMAN_FAST_BITS = 10
+	/* passes that refine the tables and the selection of a table per group */
+	HUFFMAN_PASSES = 4
+)
+
+// Huffman configures the canonical Huffman coder. The symbols are coded in
+// blocks of Block symbols, or as one block when Block is zero, and each
+// group of Group symbols is coded with the best of up to Tables code tables,
+// as bzip2 does. No code is longer than Length bits, unless the number of
+// symbols in a block needs longer codes.
+type Huffman struct {
+	Block, Tables, Group, Length int
+}
+
+type huffmanCoder struct {
+	coder Coder16
+	Huffman
+}
+
+type huffmanDecoder struct {
+	decoder Coder16
+	Huffman
+}
+
+// HuffmanCoder codes the symbols with canonical Huffman codes built for each
+// block, trading a little ratio for decoding speed
+func (coder Coder16) HuffmanCoder(huffman Huffman) Encoder {
+	checkHuffman(huffman)
+	return huffmanCoder{coder: coder, Huffman: huffman}
+}
+
+func (decoder Coder16) HuffmanDecoder(huffman Huffman) Decoder {
+	checkHuffman(huffman)
+	return huffmanDecoder{decoder: decoder, Huffman: huffman}
+}
+
+func checkHuffman(huffman Huffman) {
+	if huffman.Block < 0 || huffman.Tables < 1 || huffman.Tables > HUFFMAN_MAX_TABLES || huffman.Group < 1 ||
+		huffman.Length < 1 || huffman.Length > HUFFMAN_MAX_LENGTH {
+		panic("compress: invalid Huffman configuration")
+	}
+}
+
+// bitWriter writes bits most significant first, a buffer of bytes at a time
+type bitWriter struct {
+	out    io.Writer
+	buffer []byte
+	bits   uint64
+	count  uint
+	total  int
+}
+
+func (w *bitWriter) write(value uint32, n uint) {
+	w.bits, w.count, w.total = w.bits<<n|uint64(value), w.count+n, w.total+int(n)
+	for w.count >= 8 {
+		w.count -= 8
+		if w.buffer = append(w.buffer, byte(w.bits>>w.count)); len(w.buffer) == cap(w.buffer) {
+			w.out.Write(w.buffer)
+			w.buffer = w.buffer[:0]
+		}
+	}
+}
+
+// close pads the last byte with zeros and returns the number of bits written
+func (w *bitWriter) close() int {
+	total := w.total
+	if w.count > 0 {
+		w.write(0, 8-w.count)
+	}
+	if len(w.buffer) > 0 {
+		w.out.Write(w.buffer)
+	}
+	return total
+}
+
+// bitReader reads bits most significant first, a byte at a time, so that no
+// input past the end of the coded bits is consumed. Zeros are read past the
+// end of in, and ErrTruncated is raised if they are used.
+type bitReader struct {
+	in    io.ByteReader
+	bits  uint64
+	count uint
+	past  uint
+}
+
+func newBitReader(in io.Reader) *bitReader {
+	reader, ok := in.(io.ByteReader)
+	if !ok {
+		reader = &byteReader{Reader: in}
+	}
+	return &bitReader{in: reader}
+}
+
+func (r *bitReader) peek(n uint) uint32 {
+	for r.count < n {
+		b, err := r.in.ReadByte()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			b, r.past = 0, r.past+8
+		} else if err != nil {
+			raise(err)
+		}
+		r.bits, r.count = r.bits<<8|uint64(b), r.count+8
+	}
+	return uint32(r.bits>>(r.count-n)) & (1<<n - 1)
+}
+
+func (r *bitReader) skip(n uint) {
+	if r.count -= n; r.count < r.past {
+		raise(ErrTruncated)
+	}
+}
+
+func (r *bitReader) read(n uint) uint32 {
+	value := r.peek(n)
+	r.skip(n)
+	return value
+}
+
+func bit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for weights, no
+// longer than limit if the weights allow it; symbols of weight zero get no
+// code. Like bzip2, the weights are flattened until the code fits.
+func huffmanLengths(weights []uint64, limit uint) []uint8 {
+	lengths, leaves := make([]uint8, len(weights)), []int(nil)
+	for s, weight := range weights {
+		if weight > 0 {
+			leaves = append(leaves, s)
+		}
+	}
+	switch len(leaves) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[leaves[0]] = 1
+		return lengths
+	}
+
+	weights = append([]uint64(nil), weights...)
+	sort.SliceStable(leaves, func(i, j int) bool { return weights[leaves[i]] < weights[leaves[j]] })
+	n := len(leaves)
+	for {
+		/* the leaves are nodes 0..n-1 and the internal nodes are created in
+		   order of weight after them, so two queues find the lightest nodes */
+		weight, parent := make([]uint64, 2*n-1), make([]int, 2*n-1)
+		for i, s := range leaves {
+			weight[i] = weights[s]
+		}
+		leaf, internal := 0, n
+		lightest := func(next int) int {
+			if leaf < n && (internal >= next || weight[leaf] <= weight[internal]) {
+				leaf++
+				return leaf - 1
+			}
+			internal++
+			return internal - 1
+		}
+		for next := n; next < 2*n-1; next++ {
+			a := lightest(next)
+			b := lightest(next)
+			weight[next], parent[a], parent[b] = weight[a]+weight[b], next, next
+		}
+
+		depth, longest := make([]uint8, 2*n-1), uint8(0)
+		for i := 2*n - 3; i >= 0; i-- {
+			depth[i] = depth[parent[i]] + 1
+		}
+		for i, s := range leaves {
+			if lengths[s] = depth[i]; depth[i] > longest {
+				longest = depth[i]
+			}
+		}
+		if uint(longest) <= limit {
+			return lengths
+		}
+		for _, s := range leaves {
+			weights[s] = 1 + weights[s]/2
+		}
+		sort.SliceStable(leaves, func(i, j int) bool { return weights[leaves[i]] < weights[leaves[j]] })
+	}
+}
+
+// canonical returns the canonical codes for lengths: shorter codes first and
+// codes of the same length in the order of their symbols
+func canonical(lengths []uint8) []uint32 {
+	var count, next [HUFFMAN_MAX_LENGTH + 2]uint32
+	for _, length := range lengths {
+		count[length]++
+	}
+	count[0] = 0
+	for i := 1; i <= HUFFMAN_MAX_LENGTH+1; i++ {
+		next[i] = (next[i-1] + count[i-1]) << 1
+	}
+	codes := make([]uint32, len(lengths))
+	for s, length := range lengths {
+		if length > 0 {
+			codes[s], next[length] = next[length], next[length]+1
+		}
+	}
+	return codes
+}
+
+// Code writes each block as a one bit, its length, the symbols that occur in
+// it, its tables and their selectors and its codes; a zero bit ends the output
+func (h huffmanCoder) Code(out io.Writer) int {
+	w := &bitWriter{out: out, buffer: make([]byte, 0, 1<<12)}
+	block := []uint16(nil)
+	flush := func() {
+		if len(block) > 0 {
+			h.encode(w, block)
+			block = block[:0]
+		}
+	}
+	for input := range h.coder.Input {
+		for len(input) > 0 {
+			n := len(input)
+			if h.Block > 0 && len(block)+n > h.Block {
+				n = h.Block - len(block)
+			}
+			block, input = append(block, input[:n]...), input[n:]
+			if len(block) == h.Block {
+				flush()
+			}
+		}
+	}
+	flush()
+	w.write(0, 1)
+	return w.close()
+}
+
+func (h huffmanCoder) encode(w *bitWriter, block []uint16) {
+	size := 0
+	for _, s := range block {
+		if int(s) >= size {
+			size = int(s) + 1
+		}
+	}
+	used, symbols := make([]bool, size), 0
+	for _, s := range block {
+		if !used[s] {
+			used[s], symbols = true, symbols+1
+		}
+	}
+	limit := uint(h.Length)
+	for symbols > 1<<limit {
+		limit++
+	}
+	tables := h.Tables
+	if groups := (len(block) + h.Group - 1) / h.Group; tables > groups {
+		tables = groups
+	}
+	lengths, selectors := huffmanTables(block, used, tables, h.Group, limit)
+
+	w.write(1, 1)
+	w.write(uint32(len(block)), 32)
+	w.write(uint32(size), 17)
+	/* the symbols that occur are marked in the ranges of 16 symbols that have any */
+	ranges := make([]bool, (size+15)/16)
+	for s, u := range used {
+		ranges[s/16] = ranges[s/16] || u
+	}
+	for _, any := range ranges {
+		w.write(bit(any), 1)
+	}
+	for i, any := range ranges {
+		for s := 16 * i; any && s < 16*i+16; s++ {
+			w.write(bit(s < size && used[s]), 1)
+		}
+	}
+	w.write(uint32(tables-1), 3)
+	writeHuffman(w, block, used, h.Group, lengths, selectors)
+}
+
+// huffmanTables builds tables code tables for the symbols of block and
+// selects one for each group of symbols, refining both over a few passes.
+// Every used symbol gets a code in every table.
+func huffmanTables(block []uint16, used []bool, tables, group int, limit uint) ([][]uint8, []uint8) {
+	/* the first tables favor consecutive ranges of symbols of about equal frequency */
+	size, counts := len(used), make([]uint64, len(used))
+	for _, s := range block {
+		counts[s]++
+	}
+	lengths, start := make([][]uint8, tables), 0
+	for t := range lengths {
+		lengths[t] = make([]uint8, size)
+		target, sum := uint64(len(block))/uint64(tables), uint64(0)
+		end := start
+		for end < size && (sum < target || t == tables-1) {
+			sum, end = sum+counts[end], end+1
+		}
+		for s := range lengths[t] {
+			if lengths[t][s] = 15; s >= start && s < end {
+				lengths[t][s] = 1
+			}
+		}
+		start = end
+	}
+
+	selectors, weights := make([]uint8, (len(block)+group-1)/group), make([][]uint64, tables)
+	for pass := 0; pass < HUFFMAN_PASSES; pass++ {
+		for t := range weights {
+			weights[t] = make([]uint64, size)
+		}
+		for g := range selectors {
+			symbols, best, cost := block[g*group:], 0, ^uint(0)
+			if len(symbols) > group {
+				symbols = symbols[:group]
+			}
+			for t, table := range lengths {
+				c := uint(0)
+				for _, s := range symbols {
+					c += uint(table[s])
+				}
+				if c < cost {
+					best, cost = t, c
+				}
+			}
+			selectors[g] = uint8(best)
+			for _, s := range symbols {
+				weights[best][s] += 2
+			}
+		}
+		for t := range lengths {
+			for s, weight := range weights[t] {
+				if weight == 0 && used[s] {
+					weights[t][s] = 1
+				}
+			}
+			lengths[t] = huffmanLengths(weights[t], limit)
+		}
+	}
+	return lengths, selectors
+}
+
+// writeHuffman writes the selectors moved to front and in unary, the
+// lengths of the used symbols of each table as changes from the previous
+// length, and the codes of block
+func writeHuffman(w *bitWriter, block []uint16, used []bool, group int, lengths [][]uint8, selectors []uint8) {
+	order := [HUFFMAN_MAX_TABLES]uint8{0, 1, 2, 3, 4, 5, 6, 7}
+	for _, selector := range selectors {
+		i := 0
+		for order[i] != selector {
+			i++
+		}
+		copy(order[1:i+1], order[:i])
+		order[0] = selector
+		for ; i > 0; i-- {
+			w.write(1, 1)
+		}
+		w.write(0, 1)
+	}
+
+	codes := make([][]uint32, len(lengths))
+	for t, table := range lengths {
+		current := uint8(0)
+		for s, length := range table {
+			if !used[s] {
+				continue
+			}
+			if current == 0 {
+				current = length
+				w.write(uint32(length), 5)
+			}
+			for ; current < length; current++ {
+				w.write(2, 2)
+			}
+			for ; current > length; current-- {
+				w.write(3, 2)
+			}
+			w.write(0, 1)
+		}
+		codes[t] = canonical(table)
+	}
+
+	for g, selector := range selectors {
+		symbols, table, code := block[g*group:], lengths[selector], codes[selector]
+		if len(symbols) > group {
+			symbols = symbols[:group]
+		}
+		for _, s := range symbols {
+			w.write(code[s], uint(table[s]))
+		}
+	}
+}
+
+// huffmanTable decodes a canonical code: codes up to HUFFMAN_FAST_BITS long
+// are looked up as symbol<<8|length in fast, and longer codes are found by
+// their length from the first code and the number of codes of each length
+type huffmanTable struct {
+	fast                 [1 << HUFFMAN_FAST_BITS]uint32
+	first, count, offset [HUFFMAN_MAX_LENGTH + 1]uint32
+	sorted               []uint16
+	longest              uint
+}
+
+func newHuffmanTable(lengths []uint8) *huffmanTable {
+	table, kraft := &huffmanTable{}, uint64(0)
+	for s, length := range lengths {
+		if length > 0 {
+			table.count[length]++
+			table.sorted = append(table.sorted, uint16(s))
+			kraft += 1 << (HUFFMAN_MAX_LENGTH - length)
+			if uint(length) > table.longest {
+				table.longest = uint(length)
+			}
+		}
+	}
+	if kraft > 1<<HUFFMAN_MAX_LENGTH {
+		corrupt("huffman decoder", uint32(kraft))
+	}
+	sort.SliceStable(table.sorted, func(i, j int) bool {
+		return lengths[table.sorted[i]] < lengths[table.sorted[j]]
+	})
+
+	codes, code, offset := canonical(lengths), uint32(0), uint32(0)
+	for length := 1; length <= HUFFMAN_MAX_LENGTH; length++ {
+		code = (code + table.count[length-1]) << 1
+		table.first[length], table.offset[length] = code, offset
+		offset += table.count[length]
+	}
+	for s, length := range lengths {
+		if length == 0 || length > HUFFMAN_FAST_BITS {
+			continue
+		}
+		shift := HUFFMAN_FAST_BITS - uint(length)
+		for i := codes[s] << shift; i < (codes[s]+1)<<shift; i++ {
+			table.fast[i] = uint32(s)<<8 | uint32(length)
+		}
+	}
+	return table
+}
+
+func (t *huffmanTable) decode(r *bitReader) uint16 {
+	if entry := t.fast[r.peek(HUFFMAN_FAST_BITS)]; entry != 0 {
+		r.skip(uint(entry & 0xff))
+		return uint16(entry >> 8)
+	}
+	for length := uint(HUFFMAN_FAST_BITS + 1); length <= t.longest; length++ {
+		if code := r.peek(length) - t.first[length]; code < t.count[length] {
+			r.skip(length)
+			return t.sorted[t.offset[length]+code]
+		}
+	}
+	corrupt("huffman decoder", r.peek(t.longest))
+	return 0
+}
+
+// Decode decodes symbols from in until the output is full or the last block
+// is decoded. ErrTruncated is returned if in runs out of bits before that.
+func (h huffmanDecoder) Decode(in io.Reader) (err error) {
+	defer recoverDecode(&err)
+
+	r, cancel, alphabit := newBitReader(in), done(h.decoder.Context), int(h.decoder.Alphabit)
+	for r.read(1) == 1 {
+		select {
+		case <-cancel:
+			raise(h.decoder.Context.Err())
+		default:
+		}
+
+		n, size := int(r.read(32)), int(r.read(17))
+		if n == 0 || size == 0 || size > 1<<16 || (alphabit > 0 && size > alphabit) {
+			corrupt("huffman decoder", uint32(size))
+		}
+		used := make([]bool, size)
+		ranges := make([]bool, (size+15)/16)
+		for i := range ranges {
+			ranges[i] = r.read(1) == 1
+		}
+		for i, any := range ranges {
+			if !any {
+				continue
+			}
+			for s := 16 * i; s < 16*i+16; s++ {
+				if bit := r.read(1) == 1; s < size {
+					used[s] = bit
+				} else if bit {
+					corrupt("huffman decoder", uint32(s))
+				}
+			}
+		}
+
+		/* the selectors are appended as they are read, since a corrupt length could be huge */
+		groups := (n + h.Group - 1) / h.Group
+		tables, selectors := int(r.read(3))+1, []uint8(nil)
+		if tables > groups {
+			corrupt("huffman decoder", uint32(tables))
+		}
+		order := [HUFFMAN_MAX_TABLES]uint8{0, 1, 2, 3, 4, 5, 6, 7}
+		for g := 0; g < groups; g++ {
+			i := 0
+			for r.read(1) == 1 {
+				if i++; i >= tables {
+					corrupt("huffman decoder", uint32(i))
+				}
+			}
+			selector := order[i]
+			copy(order[1:i+1], order[:i])
+			order[0], selectors = selector, append(selectors, selector)
+		}
+
+		decoders := make([]*huffmanTable, tables)
+		for t := range decoders {
+			lengths, current := make([]uint8, size), uint32(0)
+			for s := range lengths {
+				if !used[s] {
+					continue
+				}
+				if current == 0 {
+					current = r.read(5)
+				}
+				for r.read(1) == 1 {
+					if r.read(1) == 0 {
+						current++
+					} else {
+						current--
+					}
+					if current > HUFFMAN_MAX_LENGTH {
+						corrupt("huffman decoder", current)
+					}
+				}
+				if current == 0 || current > HUFFMAN_MAX_LENGTH {
+					corrupt("huffman decoder", current)
+				}
+				lengths[s] = uint8(current)
+			}
+			decoders[t] = newHuffmanTable(lengths)
+		}
+
+		for g, selector := range selectors {
+			table, length := decoders[selector], h.Group
+			if rest := n - g*h.Group; rest < length {
+				length = rest
+			}
+			for i := 0; i < length; i++ {
+				if h.decoder.Output(table.decode(r)) {
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+const (
+	/* the hashed context tables have 1<<MIXING_TABLE_BITS counters */
+	MIXING_TABLE_BITS = 20
+	MIXING_ORDERS     = 3
+	/* the match model finds the last occurrence of the previous MIXING_MATCH_MIN symbols */
+	MIXING_MATCH_MIN  = 5
+	MIXING_MATCH_BITS = 18
+	MIXING_MATCH_MAX  = 15
+	/* order 0, the hashed orders, the match model and a bias */
+	MIXING_INPUTS = MIXING_ORDERS + 3
+	/* counters adapt ever more slowly up to 1/MIXING_LIMIT */
+	MIXING_LIMIT = 30
+	MIXING_SHIFT = 10
+	mixingScale  = 4096
+)
+
+/* the logistic function at every 128th point of the stretched domain, from paq8 */
+var squashPoints = [33]int32{1, 2, 3, 6, 10, 16, 27, 45, 73, 120, 194, 310, 488, 747, 1101, 1546, 2047, 2549, 2994,
+	3348, 3607, 3785, 3901, 3975, 4022, 4050, 4068, 4079, 4085, 4089, 4092, 4093, 4094}
+
+// squash maps a stretched probability in -2047..2047 to a 12 bit probability
+func squash(d int32) int32 {
+	if d > 2047 {
+		return 4095
+	} else if d < -2047 {
+		return 0
+	}
+	w, i := d&127, (d>>7)+16
+	return (squashPoints[i]*(128-w) + squashPoints[i+1]*w + 64) >> 7
+}
+
+// stretchTable inverts squash, which is all integer so that every platform predicts alike
+var stretchTable = func() (table [mixingScale]int32) {
+	i := int32(0)
+	for d := int32(-2047); d <= 2047; d++ {
+		for p := squash(d); i <= p; i++ {
+			table[i] = d
+		}
+	}
+	for ; i < mixingScale; i++ {
+		table[i] = 2047
+	}
+	return
+}()
+
+/* the rate of a counter after n updates is 1/(n+1.5) */
+var mixingRates = func() (rates [MIXING_LIMIT + 1]int32) {
+	for n := range rates {
+		rates[n] = 2 << MIXING_SHIFT / int32(2*n+3)
+	}
+	return
+}()
+
+// counter is a 16 bit probability of a one and the number of times it has been updated
+type counter struct {
+	p uint16
+	n uint8
+}
+
+func (c *counter) update(b uint16) {
+	target := int32(0)
+	if b != 0 {
+		target = 1<<16 - 1
+	}
+	c.p = uint16(int32(c.p) + (target-int32(c.p))*mixingRates[c.n]>>MIXING_SHIFT)
+	if c.n < MIXING_LIMIT {
+		c.n++
+	}
+}
+
+func newCounters(size int) []counter {
+	counters := make([]counter, size)
+	for i := range counters {
+		counters[i].p = 1 << 15
+	}
+	return counters
+}
+
+// mixing predicts the bits of each symbol, most significant first, from the
+// order 0 to 3 contexts of the previous symbols, each with the bits of the
+// symbol seen so far, and from the symbol that followed the last occurrence of
+// the previous symbols. A logistic mixer, with weights for each length of
+// match, combines the predictions in the stretched domain and learns online.
+type mixing struct {
+	mask, bit, bits uint16
+	node            uint32
+	order0          []counter
+	orders          [MIXING_ORDERS][]counter
+	hashes          [MIXING_ORDERS]uint32
+	match           []counter
+	history         []uint16
+	positions       []int32
+	pointer, length int
+	slots           [MIXING_INPUTS - 1]*counter
+	inputs          [MIXING_INPUTS]int32
+	weights         [MIXING_MATCH_MAX + 1][MIXING_INPUTS]int32
+	set             int
+	p               int32
+}
+
+func newMixing(alphabit uint16) *mixing {
+	highest := uint32(0)
+	for a := alphabit - 1; a > 0; a >>= 1 {
+		highest++
+	}
+	mask := uint16(1) << (highest - 1)
+	m := &mixing{mask: mask, bit: mask, node: 1, order0: newCounters(1 << highest),
+		match: newCounters(2 * (MIXING_MATCH_MAX + 1)), positions: make([]int32, 1<<MIXING_MATCH_BITS)}
+	for i := range m.orders {
+		m.orders[i] = newCounters(1 << MIXING_TABLE_BITS)
+	}
+	for i := range m.weights {
+		for j := range m.weights[i] {
+			m.weights[i][j] = 1 << 14
+		}
+	}
+	return m
+}
+
+// predict returns the probability that the next bit is a one out of mixingScale
+func (m *mixing) predict() uint16 {
+	m.slots[0] = &m.order0[m.node]
+	for i, hash := range m.hashes {
+		h := hash ^ m.node*0x9e3779b1
+		h ^= h >> 15
+		m.slots[i+1] = &m.orders[i][h*0x2c1b3c6d>>(32-MIXING_TABLE_BITS)]
+	}
+	context := 0
+	if m.length > 0 {
+		/* the match predicts until the symbol strays from it */
+		predicted, high := m.history[m.pointer], ^(m.bit<<1 - 1)
+		if predicted&high == m.bits {
+			length := m.length
+			if length > MIXING_MATCH_MAX {
+				length = MIXING_MATCH_MAX
+			}
+			context = 2 * length
+			if predicted&m.bit != 0 {
+				context++
+			}
+		}
+	}
+	m.slots[MIXING_INPUTS-2], m.set = &m.match[context], context>>1
+
+	dot, weights := int64(0), &m.weights[m.set]
+	for i, slot := range m.slots {
+		m.inputs[i] = stretchTable[slot.p>>4]
+		dot += int64(weights[i]) * int64(m.inputs[i])
+	}
+	m.inputs[MIXING_INPUTS-1] = 256
+	dot += int64(weights[MIXING_INPUTS-1]) * 256
+
+	dot >>= 16
+	if dot > 2047 {
+		dot = 2047
+	} else if dot < -2047 {
+		dot = -2047
+	}
+	if m.p = squash(int32(dot)); m.p < 1 {
+		m.p = 1
+	} else if m.p > mixingScale-1 {
+		m.p = mixingScale - 1
+	}
+	return uint16(m.p)
+}
+
+// Update trains the mixer and the counters on the bit b
+func (m *mixing) Update(b uint16) {
+	err, weights := int32(b)<<12-m.p, &m.weights[m.set]
+	for i, input := range m.inputs {
+		weights[i] += input * err >> MIXING_SHIFT
+	}
+	for _, slot := range m.slots {
+		slot.update(b)
+	}
+
+	m.node = m.node<<1 | uint32(b)
+	if b != 0 {
+		m.bits |= m.bit
+	}
+	if m.bit >>= 1; m.bit == 0 {
+		m.next(m.bits)
+		m.bit, m.bits, m.node = m.mask, 0, 1
+	}
+}
+
+// next adds s to the history, hashing the new contexts and following or finding a match
+func (m *mixing) next(s uint16) {
+	if m.length > 0 && m.history[m.pointer] == s {
+		m.length, m.pointer = m.length+1, m.pointer+1
+	} else {
+		m.length = 0
+	}
+	m.history = append(m.history, s)
+	n := len(m.history)
+
+	for i := range m.hashes {
+		h := uint32(i+1) * 0x3c6ef372
+		for j := n - i - 1; j < n; j++ {
+			if j >= 0 {
+				h = (h + uint32(m.history[j]) + 1) * 0x6f4f2a45
+				h ^= h >> 13
+			}
+		}
+		m.hashes[i] = h
+	}
+
+	if n < MIXING_MATCH_MIN {
+		return
+	}
+	h := uint32(0)
+	for _, symbol := range m.history[n-MIXING_MATCH_MIN:] {
+		h = (h + uint32(symbol) + 1) * 0x2f0b3c6d
+	}
+	h >>= 32 - MIXING_MATCH_BITS
+	if p := int(m.positions[h]); m.length == 0 && p > 0 {
+		for m.length < 1<<16 && m.length < p && m.history[p-m.length-1] == m.history[n-m.length-1] {
+			m.length++
+		}
+		m.pointer = p
+	}
+	m.positions[h] = int32(n)
+}
+
+// NewMixingBitPredictor mixes the predictions of several contexts of the
+// symbols of an alphabet of alphabit symbols, in the style of paq and cmix
+func NewMixingBitPredictor(alphabit uint16) BitPredictor {
+	return newMixing(alphabit)
+}
+
+func (m *mixing) Predict() (uint16, uint16) {
+	return mixingScale - m.predict(), mixingScale
+}
+
+// MixingBitCoder codes the bits of each symbol with the probabilities of a
+// context mixing model
+func (coder Coder16) MixingBitCoder() Model {
+	return coder.BitCoder(NewMixingBitPredictor(coder.Alphabit))
+}
+
+func (decoder Coder16) MixingBitDecoder() Model {
+	return decoder.BitDecoder(NewMixingBitPredictor(decoder.Alphabit))
+}
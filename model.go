@@ -10,310 +10,73 @@ const (
 )
 
 func (coder Coder16) AdaptiveCoder() Model {
-	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
-
-	go func() {
-		table, scale, buffer := make([]uint16, coder.Alphabit), uint16(0), [BUFFER_POOL_SIZE]Symbol{}
-		for i, _ := range table {
-			table[i] = 1
-			scale += 1
-		}
-
-		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
-		for input := range coder.Input {
-			for _, s := range input {
-				low := uint16(0)
-				for _, count := range table[:s] {
-					low += count
-				}
-
-				current[index], index = Symbol{Scale: scale, Low: low, High: low + table[s]}, index+1
-				if index == BUFFER_SIZE {
-					out <- current
-					next := offset + BUFFER_SIZE
-					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
-				}
-
-				scale++
-				table[s]++
-				if scale > MAX_SCALE16 {
-					scale = 0
-					for i, count := range table {
-						if count >>= 1; count == 0 {
-							table[i], scale = 1, scale+1
-						} else {
-							table[i], scale = count, scale+count
-						}
-					}
-				}
-			}
-		}
-
-		out <- current[:index]
-		close(out)
-	}()
-
-	return Model{Input: out}
+	return coder.FrequencyCoder(NewFrequencies16(coder.Alphabit, false))
 }
 
 func (coder Coder16) AdaptivePredictiveCoder() Model {
+	return coder.FrequencyCoder(NewFrequencies16(coder.Alphabit, true))
+}
+
+// FrequencyCoder codes with the adaptive model in frequencies, which holds the
+// trained model once the symbols have been coded
+func (coder Coder16) FrequencyCoder(frequencies *Frequencies16) Model {
 	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
 
 	go func() {
-		table, scale, context, buffer := make([][]uint16, coder.Alphabit), make([]uint16, coder.Alphabit), uint16(0), [BUFFER_POOL_SIZE]Symbol{}
-		for i, _ := range table {
-			table[i] = make([]uint16, coder.Alphabit)
-			scale[i] = coder.Alphabit
-			for j, _ := range table[i] {
-				table[i][j] = 1
-			}
-		}
+		defer close(out)
+		cancel := done(coder.Context)
 
+		buffer := [BUFFER_POOL_SIZE]Symbol{}
 		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
 		for input := range coder.Input {
 			for _, s := range input {
-				low := uint16(0)
-				for _, count := range table[context][:s] {
-					low += count
-				}
-
-				current[index], index = Symbol{Scale: scale[context], Low: low, High: low + table[context][s]}, index+1
+				current[index], index = frequencies.symbol(s), index+1
 				if index == BUFFER_SIZE {
-					out <- current
+					select {
+					case out <- current:
+					case <-cancel:
+						return
+					}
 					next := offset + BUFFER_SIZE
 					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
 				}
 
-				scale[context]++
-				table[context][s]++
-				if scale[context] > MAX_SCALE16 {
-					scale[context] = 0
-					for i, count := range table[context] {
-						if count >>= 1; count == 0 {
-							table[context][i], scale[context] = 1, scale[context]+1
-						} else {
-							table[context][i], scale[context] = count, scale[context]+count
-						}
-					}
-				}
-
-				context = s
+				frequencies.update(s)
 			}
 		}
 
-		out <- current[:index]
-		close(out)
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
 	}()
 
-	return Model{Input: out}
+	return Model{Input: out, Context: coder.Context}
 }
 
 func (coder Coder16) AdaptiveBitCoder() Model {
-	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
-
-	go func() {
-		table, buffer := [2]uint16{}, [BUFFER_POOL_SIZE]Symbol{}
-		table[0] = 1
-		table[1] = 1
-
-		highest := uint32(0)
-		for a := coder.Alphabit - 1; a > 0; a >>= 1 {
-			highest++
-		}
-
-		current, offset, index, mask := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0, uint16(1)<<(highest-1)
-		for input := range coder.Input {
-			for _, s := range input {
-				for bit := mask; bit > 0; bit >>= 1 {
-					b, low, high, scale := uint16(0), uint16(0), table[0], table[0]+table[1]
-					if bit&s != 0 {
-						b, low, high = 1, high, scale
-					}
-
-					current[index], index = Symbol{Scale: scale, Low: low, High: high}, index+1
-					if index == BUFFER_SIZE {
-						out <- current
-						next := offset + BUFFER_SIZE
-						current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
-					}
-
-					table[b]++
-					if scale >= MAX_SCALE16 {
-						table[0] >>= 1
-						table[1] >>= 1
-						if table[0] == 0 {
-							table[0] = 1
-						}
-						if table[1] == 0 {
-							table[1] = 1
-						}
-					}
-				}
-			}
-		}
-
-		out <- current[:index]
-		close(out)
-	}()
-
-	return Model{Input: out}
+	return coder.BitCoder(NewAdaptiveBitPredictor())
 }
 
 func (coder Coder16) AdaptivePredictiveBitCoder() Model {
-	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
-
-	go func() {
-		table, context, buffer := make([][2]uint16, 65536), uint16(0), [BUFFER_POOL_SIZE]Symbol{}
-		for i, _ := range table {
-			table[i][0] = 1
-			table[i][1] = 1
-		}
-
-		highest := uint32(0)
-		for a := coder.Alphabit - 1; a > 0; a >>= 1 {
-			highest++
-		}
-
-		current, offset, index, mask := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0, uint16(1)<<(highest-1)
-		for input := range coder.Input {
-			for _, s := range input {
-				for bit := mask; bit > 0; bit >>= 1 {
-					b, low, high, scale := uint16(0), uint16(0), table[context][0], table[context][0]+table[context][1]
-					if bit&s != 0 {
-						b, low, high = 1, high, scale
-					}
-
-					current[index], index = Symbol{Scale: scale, Low: low, High: high}, index+1
-					if index == BUFFER_SIZE {
-						out <- current
-						next := offset + BUFFER_SIZE
-						current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
-					}
-
-					table[context][b]++
-					if scale >= MAX_SCALE16 {
-						table[context][0] >>= 1
-						table[context][1] >>= 1
-						if table[context][0] == 0 {
-							table[context][0] = 1
-						}
-						if table[context][1] == 0 {
-							table[context][1] = 1
-						}
-					}
-
-					context = b | (context << 1)
-				}
-			}
-		}
-
-		out <- current[:index]
-		close(out)
-	}()
-
-	return Model{Input: out}
+	return coder.BitCoder(NewAdaptivePredictiveBitPredictor())
 }
 
-// https://fgiesen.wordpress.com/2015/05/26/models-for-adaptive-arithmetic-coding/
 func (coder Coder16) FilteredAdaptiveBitCoder() Model {
-	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
-
-	go func() {
-		const scale = uint16(filterScale)
-		p1 := scale / 2
-
-		buffer := [BUFFER_POOL_SIZE]Symbol{}
-
-		highest := uint32(0)
-		for a := coder.Alphabit - 1; a > 0; a >>= 1 {
-			highest++
-		}
-
-		current, offset, index, mask := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0, uint16(1)<<(highest-1)
-		for input := range coder.Input {
-			for _, s := range input {
-				for bit := mask; bit > 0; bit >>= 1 {
-					b, low, high := uint16(0), uint16(0), scale-p1
-					if bit&s != 0 {
-						b, low, high = 1, high, scale
-					}
-
-					current[index], index = Symbol{Scale: scale, Low: low, High: high}, index+1
-					if index == BUFFER_SIZE {
-						out <- current
-						next := offset + BUFFER_SIZE
-						current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
-					}
-
-					if b == 0 {
-						p1 -= p1 >> filterShift
-					} else {
-						p1 += (scale - p1) >> filterShift
-					}
-				}
-			}
-		}
-
-		out <- current[:index]
-		close(out)
-	}()
-
-	return Model{Input: out}
+	return coder.BitCoder(NewFilteredAdaptiveBitPredictor())
 }
 
 func (coder Coder16) FilteredAdaptivePredictiveBitCoder() Model {
-	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
-
-	go func() {
-		const scale = uint16(filterScale)
-		table, context, buffer := make([]uint16, 65536), uint16(0), [BUFFER_POOL_SIZE]Symbol{}
-		for i, _ := range table {
-			table[i] = scale / 2
-		}
-
-		highest := uint32(0)
-		for a := coder.Alphabit - 1; a > 0; a >>= 1 {
-			highest++
-		}
-
-		current, offset, index, mask := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0, uint16(1)<<(highest-1)
-		for input := range coder.Input {
-			for _, s := range input {
-				for bit := mask; bit > 0; bit >>= 1 {
-					b, low, high := uint16(0), uint16(0), scale-table[context]
-					if bit&s != 0 {
-						b, low, high = 1, high, scale
-					}
-
-					current[index], index = Symbol{Scale: scale, Low: low, High: high}, index+1
-					if index == BUFFER_SIZE {
-						out <- current
-						next := offset + BUFFER_SIZE
-						current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
-					}
-
-					if b == 0 {
-						table[context] -= table[context] >> filterShift
-					} else {
-						table[context] += (scale - table[context]) >> filterShift
-					}
-
-					context = b | (context << 1)
-				}
-			}
-		}
-
-		out <- current[:index]
-		close(out)
-	}()
-
-	return Model{Input: out}
+	return coder.BitCoder(NewFilteredAdaptivePredictiveBitPredictor())
 }
 
 func (coder Coder16) FilteredAdaptiveCoder(newCDF CDF16Maker) Model {
 	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
 
 	go func() {
+		defer close(out)
+		cancel := done(coder.Context)
+
 		cdf := newCDF(int(coder.Alphabit))
 		buffer := [BUFFER_POOL_SIZE]Symbol{}
 
@@ -323,7 +86,11 @@ func (coder Coder16) FilteredAdaptiveCoder(newCDF CDF16Maker) Model {
 				model := cdf.Model()
 				current[index], index = Symbol{Low: model[s], High: model[s+1]}, index+1
 				if index == BUFFER_SIZE {
-					out <- current
+					select {
+					case out <- current:
+					case <-cancel:
+						return
+					}
 					next := offset + BUFFER_SIZE
 					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
 				}
@@ -332,263 +99,56 @@ func (coder Coder16) FilteredAdaptiveCoder(newCDF CDF16Maker) Model {
 			}
 		}
 
-		out <- current[:index]
-		close(out)
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
 	}()
 
-	return Model{Fixed: CDF16Fixed, Input: out}
+	return Model{Fixed: CDF16Fixed, Input: out, Context: coder.Context}
 }
 
 func (decoder Coder16) AdaptiveDecoder() Model {
-	table, scale := make([]uint16, decoder.Alphabit), uint16(0)
-	for i, _ := range table {
-		table[i] = 1
-		scale += 1
-	}
-
-	lookup := func(code uint16) Symbol {
-		low, high, done := uint16(0), uint16(0), false
-		for s, count := range table {
-			if high += count; code < high {
-				low, done, scale, table[s] = high-count, decoder.Output(uint16(s)), scale+1, table[s]+1
-				break
-			}
-		}
-
-		if done {
-			return Symbol{}
-		} else {
-			if scale > MAX_SCALE16 {
-				scale = 0
-				for i, count := range table {
-					if count >>= 1; count == 0 {
-						table[i], scale = 1, scale+1
-					} else {
-						table[i], scale = count, scale+count
-					}
-				}
-			}
-
-			return Symbol{Scale: scale, Low: low, High: high}
-		}
-	}
-
-	return Model{Scale: uint32(scale), Output: lookup}
+	return decoder.FrequencyDecoder(NewFrequencies16(decoder.Alphabit, false))
 }
 
 func (decoder Coder16) AdaptivePredictiveDecoder() Model {
-	table, scale, context := make([][]uint16, decoder.Alphabit), make([]uint16, decoder.Alphabit), uint16(0)
-	for i, _ := range table {
-		table[i] = make([]uint16, decoder.Alphabit)
-		scale[i] = decoder.Alphabit
-		for j, _ := range table[i] {
-			table[i][j] = 1
-		}
-	}
+	return decoder.FrequencyDecoder(NewFrequencies16(decoder.Alphabit, true))
+}
 
+// FrequencyDecoder decodes with the adaptive model in frequencies
+func (decoder Coder16) FrequencyDecoder(frequencies *Frequencies16) Model {
 	lookup := func(code uint16) Symbol {
-		low, high, next, done := uint16(0), uint16(0), uint16(0), false
-		for s, count := range table[context] {
-			if high += count; code < high {
-				next = uint16(s)
-				low, done, scale[context], table[context][s] = high-count, decoder.Output(next), scale[context]+1, table[context][s]+1
-				break
-			}
+		s, low, high, ok := frequencies.find(code)
+		if !ok {
+			corrupt("adaptive decoder", uint32(code))
 		}
 
+		done := decoder.Output(s)
+		frequencies.update(s)
 		if done {
 			return Symbol{}
-		} else {
-			if scale[context] > MAX_SCALE16 {
-				scale[context] = 0
-				for i, count := range table[context] {
-					if count >>= 1; count == 0 {
-						table[context][i], scale[context] = 1, scale[context]+1
-					} else {
-						table[context][i], scale[context] = count, scale[context]+count
-					}
-				}
-			}
-
-			context = next
-			return Symbol{Scale: scale[context], Low: low, High: high}
 		}
+		return Symbol{Scale: frequencies.Scales[frequencies.Context], Low: low, High: high}
 	}
 
-	return Model{Scale: uint32(decoder.Alphabit), Output: lookup}
+	return Model{Scale: uint32(frequencies.Scales[frequencies.Context]), Output: lookup, Context: decoder.Context}
 }
 
 func (decoder Coder16) AdaptiveBitDecoder() Model {
-	table := [2]uint16{}
-	table[0] = 1
-	table[1] = 1
-
-	highest := uint32(0)
-	for a := decoder.Alphabit - 1; a > 0; a >>= 1 {
-		highest++
-	}
-	mask := uint16(1) << (highest - 1)
-	bit, bits := mask, uint16(0)
-
-	lookup := func(code uint16) Symbol {
-		scale, low, high, b := table[0]+table[1], uint16(0), uint16(0), uint16(0)
-		if code < table[0] {
-			high, bit = table[0], bit>>1
-		} else {
-			low, high, bits, bit, b = table[0], scale, bits|bit, bit>>1, 1
-		}
-
-		table[b]++
-		if scale >= MAX_SCALE16 {
-			table[0] >>= 1
-			table[1] >>= 1
-			if table[0] == 0 {
-				table[0] = 1
-			}
-			if table[1] == 0 {
-				table[1] = 1
-			}
-		}
-
-		if bit == 0 {
-			if decoder.Output(bits) {
-				return Symbol{}
-			}
-			bits, bit = 0, mask
-		}
-
-		return Symbol{Scale: table[0] + table[1], Low: low, High: high}
-	}
-
-	return Model{Scale: uint32(2), Output: lookup}
+	return decoder.BitDecoder(NewAdaptiveBitPredictor())
 }
 
 func (decoder Coder16) AdaptivePredictiveBitDecoder() Model {
-	table, context := make([][2]uint16, 65536), uint16(0)
-	for i, _ := range table {
-		table[i][0] = 1
-		table[i][1] = 1
-	}
-
-	highest := uint32(0)
-	for a := decoder.Alphabit - 1; a > 0; a >>= 1 {
-		highest++
-	}
-	mask := uint16(1) << (highest - 1)
-	bit, bits := mask, uint16(0)
-
-	lookup := func(code uint16) Symbol {
-		scale, low, high, b := table[context][0]+table[context][1], uint16(0), uint16(0), uint16(0)
-		if code < table[context][0] {
-			high, bit = table[context][0], bit>>1
-		} else {
-			low, high, bits, bit, b = table[context][0], scale, bits|bit, bit>>1, 1
-		}
-
-		table[context][b]++
-		if scale >= MAX_SCALE16 {
-			table[context][0] >>= 1
-			table[context][1] >>= 1
-			if table[context][0] == 0 {
-				table[context][0] = 1
-			}
-			if table[context][1] == 0 {
-				table[context][1] = 1
-			}
-		}
-		context = b | (context << 1)
-
-		if bit == 0 {
-			if decoder.Output(bits) {
-				return Symbol{}
-			}
-			bits, bit = 0, mask
-		}
-
-		return Symbol{Scale: table[context][0] + table[context][1], Low: low, High: high}
-	}
-
-	return Model{Scale: uint32(2), Output: lookup}
+	return decoder.BitDecoder(NewAdaptivePredictiveBitPredictor())
 }
 
 func (decoder Coder16) FilteredAdaptiveBitDecoder() Model {
-	const scale = uint16(filterScale)
-	p1 := scale / 2
-
-	highest := uint32(0)
-	for a := decoder.Alphabit - 1; a > 0; a >>= 1 {
-		highest++
-	}
-	mask := uint16(1) << (highest - 1)
-	bit, bits := mask, uint16(0)
-
-	lookup := func(code uint16) Symbol {
-		low, high, b := uint16(0), uint16(0), uint16(0)
-		if p0 := scale - p1; code < p0 {
-			high, bit = p0, bit>>1
-		} else {
-			low, high, bits, bit, b = p0, scale, bits|bit, bit>>1, 1
-		}
-
-		if b == 0 {
-			p1 -= p1 >> filterShift
-		} else {
-			p1 += (scale - p1) >> filterShift
-		}
-
-		if bit == 0 {
-			if decoder.Output(bits) {
-				return Symbol{}
-			}
-			bits, bit = 0, mask
-		}
-
-		return Symbol{Scale: scale, Low: low, High: high}
-	}
-
-	return Model{Scale: uint32(scale), Output: lookup}
+	return decoder.BitDecoder(NewFilteredAdaptiveBitPredictor())
 }
 
 func (decoder Coder16) FilteredAdaptivePredictiveBitDecoder() Model {
-	const scale = uint16(filterScale)
-	table, context := make([]uint16, 65536), uint16(0)
-	for i, _ := range table {
-		table[i] = scale / 2
-	}
-
-	highest := uint32(0)
-	for a := decoder.Alphabit - 1; a > 0; a >>= 1 {
-		highest++
-	}
-	mask := uint16(1) << (highest - 1)
-	bit, bits := mask, uint16(0)
-
-	lookup := func(code uint16) Symbol {
-		low, high, b := uint16(0), uint16(0), uint16(0)
-		if p0 := scale - table[context]; code < p0 {
-			high, bit = p0, bit>>1
-		} else {
-			low, high, bits, bit, b = p0, scale, bits|bit, bit>>1, 1
-		}
-
-		if b == 0 {
-			table[context] -= table[context] >> filterShift
-		} else {
-			table[context] += (scale - table[context]) >> filterShift
-		}
-		context = b | (context << 1)
-
-		if bit == 0 {
-			if decoder.Output(bits) {
-				return Symbol{}
-			}
-			bits, bit = 0, mask
-		}
-
-		return Symbol{Scale: scale, Low: low, High: high}
-	}
-
-	return Model{Scale: uint32(scale), Output: lookup}
+	return decoder.BitDecoder(NewFilteredAdaptivePredictiveBitPredictor())
 }
 
 func (decoder Coder16) FilteredAdaptiveDecoder(newCDF CDF16Maker) Model {
@@ -606,6 +166,9 @@ func (decoder Coder16) FilteredAdaptiveDecoder(newCDF CDF16Maker) Model {
 				break
 			}
 		}
+		if high == 0 {
+			corrupt("filtered adaptive decoder", uint32(code))
+		}
 
 		if done {
 			return Symbol{}
@@ -614,110 +177,61 @@ func (decoder Coder16) FilteredAdaptiveDecoder(newCDF CDF16Maker) Model {
 		return Symbol{Low: low, High: high}
 	}
 
-	return Model{Fixed: CDF16Fixed, Output: lookup}
+	return Model{Fixed: CDF16Fixed, Output: lookup, Context: decoder.Context}
 }
 
 func (coder Coder16) AdaptiveCoder32() Model32 {
-	out := make(chan []Symbol32, BUFFER_CHAN_SIZE)
-
-	go func() {
-		table, scale, buffer := make([]uint32, coder.Alphabit), uint32(coder.Alphabit), [BUFFER_POOL_SIZE]Symbol32{}
-		for i, _ := range table {
-			table[i] = 1
-		}
-
-		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
-		for input := range coder.Input {
-			for _, s := range input {
-				low := uint32(0)
-				for _, count := range table[:s] {
-					low += count
-				}
-
-				current[index], index = Symbol32{Scale: scale, Low: low, High: low + table[s]}, index+1
-				if index == BUFFER_SIZE {
-					out <- current
-					next := offset + BUFFER_SIZE
-					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
-				}
-
-				scale++
-				table[s]++
-				if scale > MAX_SCALE32 {
-					scale = 0
-					for i, count := range table {
-						if count >>= 1; count == 0 {
-							table[i], scale = 1, scale+1
-						} else {
-							table[i], scale = count, scale+count
-						}
-					}
-				}
-			}
-		}
-
-		out <- current[:index]
-		close(out)
-	}()
-
-	return Model32{Input: out}
+	return coder.FrequencyCoder32(NewFrequencies32(coder.Alphabit, false))
 }
 
 func (coder Coder16) AdaptivePredictiveCoder32() Model32 {
+	return coder.FrequencyCoder32(NewFrequencies32(coder.Alphabit, true))
+}
+
+// FrequencyCoder32 codes with the adaptive model in frequencies, which holds the
+// trained model once the symbols have been coded
+func (coder Coder16) FrequencyCoder32(frequencies *Frequencies32) Model32 {
 	out := make(chan []Symbol32, BUFFER_CHAN_SIZE)
 
 	go func() {
-		table, scale, context, buffer := make([][]uint32, coder.Alphabit), make([]uint32, coder.Alphabit), uint16(0), [BUFFER_POOL_SIZE]Symbol32{}
-		for i, _ := range table {
-			table[i] = make([]uint32, coder.Alphabit)
-			scale[i] = uint32(coder.Alphabit)
-			for j, _ := range table[i] {
-				table[i][j] = 1
-			}
-		}
+		defer close(out)
+		cancel := done(coder.Context)
 
+		buffer := [BUFFER_POOL_SIZE]Symbol32{}
 		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
 		for input := range coder.Input {
 			for _, s := range input {
-				low := uint32(0)
-				for _, count := range table[context][:s] {
-					low += count
-				}
-
-				current[index], index = Symbol32{Scale: scale[context], Low: low, High: low + table[context][s]}, index+1
+				current[index], index = frequencies.symbol(s), index+1
 				if index == BUFFER_SIZE {
-					out <- current
+					select {
+					case out <- current:
+					case <-cancel:
+						return
+					}
 					next := offset + BUFFER_SIZE
 					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
 				}
 
-				scale[context]++
-				table[context][s]++
-				if scale[context] > MAX_SCALE32 {
-					scale[context] = 0
-					for i, count := range table[context] {
-						if count >>= 1; count == 0 {
-							table[context][i], scale[context] = 1, scale[context]+1
-						} else {
-							table[context][i], scale[context] = count, scale[context]+count
-						}
-					}
-				}
-				context = s
+				frequencies.update(s)
 			}
 		}
 
-		out <- current[:index]
-		close(out)
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
 	}()
 
-	return Model32{Input: out}
+	return Model32{Input: out, Context: coder.Context}
 }
 
 func (coder Coder16) FilteredAdaptiveCoder32(newCDF CDF32Maker) Model32 {
 	out := make(chan []Symbol32, BUFFER_CHAN_SIZE)
 
 	go func() {
+		defer close(out)
+		cancel := done(coder.Context)
+
 		cdf := newCDF(int(coder.Alphabit))
 		buffer := [BUFFER_POOL_SIZE]Symbol32{}
 
@@ -727,7 +241,11 @@ func (coder Coder16) FilteredAdaptiveCoder32(newCDF CDF32Maker) Model32 {
 				model := cdf.Model()
 				current[index], index = Symbol32{Low: model[s], High: model[s+1]}, index+1
 				if index == BUFFER_SIZE {
-					out <- current
+					select {
+					case out <- current:
+					case <-cancel:
+						return
+					}
 					next := offset + BUFFER_SIZE
 					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
 				}
@@ -736,90 +254,40 @@ func (coder Coder16) FilteredAdaptiveCoder32(newCDF CDF32Maker) Model32 {
 			}
 		}
 
-		out <- current[:index]
-		close(out)
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
 	}()
 
-	return Model32{Fixed: CDF32Fixed, Input: out}
+	return Model32{Fixed: CDF32Fixed, Input: out, Context: coder.Context}
 }
 
 func (decoder Coder16) AdaptiveDecoder32() Model32 {
-	table, scale := make([]uint32, decoder.Alphabit), uint32(decoder.Alphabit)
-	for i, _ := range table {
-		table[i] = 1
-	}
-
-	lookup := func(code uint32) Symbol32 {
-		low, high, done := uint32(0), uint32(0), false
-		for s, count := range table {
-			if high += count; code < high {
-				low, done, scale, table[s] = high-count, decoder.Output(uint16(s)), scale+1, table[s]+1
-				break
-			}
-		}
-
-		if done {
-			return Symbol32{}
-		} else {
-			if scale > MAX_SCALE32 {
-				scale = 0
-				for i, count := range table {
-					if count >>= 1; count == 0 {
-						table[i], scale = 1, scale+1
-					} else {
-						table[i], scale = count, scale+count
-					}
-				}
-			}
-
-			return Symbol32{Scale: scale, Low: low, High: high}
-		}
-	}
-
-	return Model32{Scale: uint64(decoder.Alphabit), Output: lookup}
+	return decoder.FrequencyDecoder32(NewFrequencies32(decoder.Alphabit, false))
 }
 
 func (decoder Coder16) AdaptivePredictiveDecoder32() Model32 {
-	table, scale, context := make([][]uint32, decoder.Alphabit), make([]uint32, decoder.Alphabit), uint16(0)
-	for i, _ := range table {
-		table[i] = make([]uint32, decoder.Alphabit)
-		scale[i] = uint32(decoder.Alphabit)
-		for j, _ := range table[i] {
-			table[i][j] = 1
-		}
-	}
+	return decoder.FrequencyDecoder32(NewFrequencies32(decoder.Alphabit, true))
+}
 
+// FrequencyDecoder32 decodes with the adaptive model in frequencies
+func (decoder Coder16) FrequencyDecoder32(frequencies *Frequencies32) Model32 {
 	lookup := func(code uint32) Symbol32 {
-		low, high, next, done := uint32(0), uint32(0), uint16(0), false
-		for s, count := range table[context] {
-			if high += count; code < high {
-				next = uint16(s)
-				low, done, scale[context], table[context][s] = high-count, decoder.Output(next), scale[context]+1, table[context][s]+1
-				break
-			}
+		s, low, high, ok := frequencies.find(code)
+		if !ok {
+			corrupt("adaptive decoder", code)
 		}
 
+		done := decoder.Output(s)
+		frequencies.update(s)
 		if done {
 			return Symbol32{}
-		} else {
-			if scale[context] > MAX_SCALE32 {
-				scale[context] = 0
-				for i, count := range table[context] {
-					if count >>= 1; count == 0 {
-						table[context][i], scale[context] = 1, scale[context]+1
-					} else {
-						table[context][i], scale[context] = count, scale[context]+count
-					}
-				}
-			}
-
-			context = next
-			return Symbol32{Scale: scale[context], Low: low, High: high}
 		}
-
+		return Symbol32{Scale: frequencies.Scales[frequencies.Context], Low: low, High: high}
 	}
 
-	return Model32{Scale: uint64(decoder.Alphabit), Output: lookup}
+	return Model32{Scale: uint64(frequencies.Scales[frequencies.Context]), Output: lookup, Context: decoder.Context}
 }
 
 func (decoder Coder16) FilteredAdaptiveDecoder32(newCDF CDF32Maker) Model32 {
@@ -837,6 +305,9 @@ func (decoder Coder16) FilteredAdaptiveDecoder32(newCDF CDF32Maker) Model32 {
 				break
 			}
 		}
+		if high == 0 {
+			corrupt("filtered adaptive decoder", uint32(code))
+		}
 
 		if done {
 			return Symbol32{}
@@ -845,5 +316,5 @@ func (decoder Coder16) FilteredAdaptiveDecoder32(newCDF CDF32Maker) Model32 {
 		return Symbol32{Low: low, High: high}
 	}
 
-	return Model32{Fixed: CDF32Fixed, Output: lookup}
+	return Model32{Fixed: CDF32Fixed, Output: lookup, Context: decoder.Context}
 }
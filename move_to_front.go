@@ -8,6 +8,9 @@ func (coder Coder8) MoveToFrontCoder() Coder16 {
 	symbols := make(chan []uint16, BUFFER_CHAN_SIZE)
 
 	go func() {
+		defer close(symbols)
+		cancel := done(coder.Context)
+
 		nodes, buffer := [256]byte{}, [BUFFER_POOL_SIZE]uint16{}
 		var first byte
 
@@ -30,18 +33,24 @@ func (coder Coder8) MoveToFrontCoder() Coder16 {
 				}
 
 				if index == BUFFER_SIZE {
-					symbols <- current
+					select {
+					case symbols <- current:
+					case <-cancel:
+						return
+					}
 					next := offset + BUFFER_SIZE
 					current, offset, index = buffer[offset:next], next & BUFFER_POOL_SIZE_MASK, 0
 				}
  			}
 		}
 
-		symbols <- current[:index]
-		close(symbols)
+		select {
+		case symbols <- current[:index]:
+		case <-cancel:
+		}
 	}()
 
-	return Coder16{Alphabit:256, Input:symbols}
+	return Coder16{Alphabit:256, Input:symbols, Context:coder.Context}
 }
 
 func (coder Coder8) MoveToFrontDecoder() Coder16 {
@@ -53,6 +62,9 @@ func (coder Coder8) MoveToFrontDecoder() Coder16 {
 	}
 
 	output := func(symbol uint16) bool {
+		if symbol > 255 {
+			corrupt("move to front decoder", uint32(symbol))
+		}
 		var node, next byte
 		moveToFront := symbol != 0
 		for next = first; symbol > 0; node, next = next, nodes[next] {
@@ -66,33 +78,46 @@ func (coder Coder8) MoveToFrontDecoder() Coder16 {
 		return coder.Output(next)
 	}
 
-	return Coder16{Alphabit:256, Output:output}
+	return Coder16{Alphabit:256, Output:output, Context:coder.Context}
 }
 
 func (coder Coder8) MoveToFrontRunLengthCoder() Coder16 {
 	symbols := make(chan []uint16, BUFFER_CHAN_SIZE)
 
 	go func() {
+		defer close(symbols)
+		cancel := done(coder.Context)
+
 		var buffer [BUFFER_POOL_SIZE]uint16
 		current, offset, index, length := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0, uint64(0)
-		outputSymbol := func(symbol uint16) {
+		outputSymbol := func(symbol uint16) bool {
 			current[index], index = symbol, index + 1
 			if index == BUFFER_SIZE {
-				symbols <- current
+				select {
+				case symbols <- current:
+				case <-cancel:
+					return false
+				}
 				next := offset + BUFFER_SIZE
 				current, offset, index = buffer[offset:next], next & BUFFER_POOL_SIZE_MASK, 0
 			}
+			return true
 		}
-		outputLength := func() {
+		outputLength := func() bool {
 			if length > 0 {
 				length--
-				outputSymbol(uint16(length & 1))
+				if !outputSymbol(uint16(length & 1)) {
+					return false
+				}
 				for length > 1 {
 					length = (length - 2) >> 1
-					outputSymbol(uint16(length & 1))
+					if !outputSymbol(uint16(length & 1)) {
+						return false
+					}
 				}
 				length = 0
 			}
+			return true
 		}
 
 		var nodes [256]byte
@@ -116,17 +141,22 @@ func (coder Coder8) MoveToFrontRunLengthCoder() Coder16 {
 
 				first, nodes[node], nodes[next] = next, nodes[next], first
 
-				outputLength()
-				outputSymbol(symbol + 1)
+				if !outputLength() || !outputSymbol(symbol + 1) {
+					return
+				}
  			}
 		}
 
-		outputLength()
-		symbols <- current[:index]
-		close(symbols)
+		if !outputLength() {
+			return
+		}
+		select {
+		case symbols <- current[:index]:
+		case <-cancel:
+		}
 	}()
 
-	return Coder16{Alphabit:257, Input:symbols}
+	return Coder16{Alphabit:257, Input:symbols, Context:coder.Context}
 }
 
 func (coder Coder8) MoveToFrontRunLengthDecoder() Coder16 {
@@ -139,6 +169,9 @@ func (coder Coder8) MoveToFrontRunLengthDecoder() Coder16 {
 
 	length := uint64(1)
 	output := func(symbol uint16) bool {
+		if symbol > 256 {
+			corrupt("move to front run length decoder", uint32(symbol))
+		}
 		if symbol > 1 {
 			var node, next byte
 			symbol, length = symbol - 1, 1
@@ -159,5 +192,5 @@ func (coder Coder8) MoveToFrontRunLengthDecoder() Coder16 {
 		return false
 	}
 
-	return Coder16{Alphabit:257, Output:output}
+	return Coder16{Alphabit:257, Output:output, Context:coder.Context}
 }
@@ -0,0 +1,401 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	PPM_MAX_ORDER = 16
+	// PPMMaxSize is the largest alphabet of a PPM model, which leaves most of MAX_SCALE16 for the counts
+	PPMMaxSize = 1 << 12
+	/* the counts of a context are halved once their sum passes PPM_MAX_TOTAL */
+	PPM_MAX_TOTAL = (MAX_SCALE16 - PPMMaxSize) / 2
+)
+
+// PPMMethod is how a PPM context estimates the probability of an escape
+type PPMMethod int
+
+const (
+	// PPMC gives a symbol seen c times c counts and the escape a count for each distinct symbol
+	PPMC PPMMethod = iota
+	// PPMD gives a symbol 2c-1 counts, halving the weight of the escape
+	PPMD
+)
+
+// PPM configures prediction by partial matching. A symbol is coded in the
+// longest context of up to Order previous symbols that has seen it, escaping
+// from each longer context, whose symbols are then excluded from the shorter
+// ones. A symbol no context has seen is coded out of the whole alphabet. The
+// contexts are kept in a tree whose memory is bounded by Budget, which cannot
+// be Hashed.
+type PPM struct {
+	Order  int
+	Method PPMMethod
+	Budget Budget
+}
+
+type ppmEntry struct {
+	symbol, count uint16
+}
+
+// ppmNode is a context: the symbols seen after it with their counts, and its
+// children, the contexts one symbol longer
+type ppmNode struct {
+	entries  []ppmEntry
+	total    int
+	children map[uint16]*ppmNode
+	used     uint64
+}
+
+// ppm is the state shared by the coder and the decoder. The contexts of the
+// current symbol are in chain, longest last, and level is the index of the
+// context being coded, -1 being the whole alphabet.
+type ppm struct {
+	PPM
+	size     int
+	root     *ppmNode
+	nodes    int
+	clock    uint64
+	history  []uint16
+	chain    []*ppmNode
+	level    int
+	excluded []uint32
+	stamp    uint32
+	count    int
+}
+
+func checkPPM(p PPM, size int) {
+	if size < 2 || size > PPMMaxSize {
+		panic(fmt.Sprintf("compress: PPM alphabet of %d symbols is not in 2..%d", size, PPMMaxSize))
+	}
+	if p.Order < 0 || p.Order > PPM_MAX_ORDER || p.Method < PPMC || p.Method > PPMD || p.Budget.Hashed ||
+		p.Budget.Policy < PolicyReset || p.Budget.Policy > PolicyFreeze {
+		panic("compress: invalid PPM configuration")
+	}
+}
+
+func newPPM(p PPM, size int) *ppm {
+	checkPPM(p, size)
+	return &ppm{PPM: p, size: size, root: &ppmNode{}, nodes: 1, history: make([]uint16, 0, p.Order),
+		chain: make([]*ppmNode, 0, p.Order+1), excluded: make([]uint32, size)}
+}
+
+func (p *ppm) frequency(count uint16) int {
+	if p.Method == PPMD {
+		return 2*int(count) - 1
+	}
+	return int(count)
+}
+
+// find finds the contexts of the next symbol
+func (p *ppm) find() {
+	if budget := p.Budget.Nodes; budget > 0 && p.nodes+p.Order > budget {
+		switch p.Budget.Policy {
+		case PolicyReset:
+			p.root.children, p.nodes = nil, 1
+		case PolicyPrune:
+			p.prune()
+		}
+	}
+
+	p.clock++
+	n := p.root
+	p.chain = append(p.chain[:0], n)
+	n.used = p.clock
+	for _, s := range p.history {
+		if n = n.children[s]; n == nil {
+			break
+		}
+		n.used, p.chain = p.clock, append(p.chain, n)
+	}
+}
+
+// start moves to the longest context that can code the next symbol, excluding the symbol excluded unless it
+// is negative
+func (p *ppm) start(excluded int) {
+	p.stamp, p.count, p.level = p.stamp+1, 0, len(p.chain)
+	if excluded >= 0 {
+		p.excluded[excluded], p.count = p.stamp, 1
+	}
+	p.next()
+}
+
+// locate moves to the longest context that has seen s, for updating after s was coded by another model
+func (p *ppm) locate(s uint16) {
+	for p.level = len(p.chain) - 1; p.level >= 0; p.level-- {
+		for _, entry := range p.chain[p.level].entries {
+			if entry.symbol == s {
+				return
+			}
+		}
+	}
+}
+
+// next moves to the next shorter context that has a symbol that is not excluded
+func (p *ppm) next() {
+	for p.level--; p.level >= 0; p.level-- {
+		for _, entry := range p.chain[p.level].entries {
+			if p.excluded[entry.symbol] != p.stamp {
+				return
+			}
+		}
+	}
+}
+
+// exclude excludes the symbols of the current context from the shorter ones
+func (p *ppm) exclude() {
+	for _, entry := range p.chain[p.level].entries {
+		if p.excluded[entry.symbol] != p.stamp {
+			p.excluded[entry.symbol], p.count = p.stamp, p.count+1
+		}
+	}
+}
+
+// scale returns the sum of the frequencies of the symbols of the current context that are not excluded,
+// and the count of the escape after them
+func (p *ppm) scale() (sum, escape int) {
+	if p.level < 0 {
+		return p.size - p.count, 0
+	}
+	for _, entry := range p.chain[p.level].entries {
+		if p.excluded[entry.symbol] != p.stamp {
+			sum, escape = sum+p.frequency(entry.count), escape+1
+		}
+	}
+	return sum, escape
+}
+
+// update counts s in the context that coded it, adds it to the longer contexts, and adds the contexts of
+// up to Order symbols that are missing
+func (p *ppm) update(s uint16) {
+	for i := len(p.chain) - 1; i >= 0 && i >= p.level; i-- {
+		n := p.chain[i]
+		if i > p.level {
+			n.entries = append(n.entries, ppmEntry{symbol: s, count: 1})
+		} else {
+			for j := range n.entries {
+				if n.entries[j].symbol == s {
+					n.entries[j].count++
+					break
+				}
+			}
+		}
+		if n.total++; n.total > PPM_MAX_TOTAL {
+			n.total = 0
+			for j := range n.entries {
+				n.entries[j].count = (n.entries[j].count + 1) >> 1
+				n.total += int(n.entries[j].count)
+			}
+		}
+	}
+
+	n, budget := p.chain[len(p.chain)-1], p.Budget.Nodes
+	for _, symbol := range p.history[len(p.chain)-1:] {
+		if budget > 0 && p.nodes >= budget {
+			break
+		}
+		if n.children == nil {
+			n.children = make(map[uint16]*ppmNode)
+		}
+		child := &ppmNode{entries: []ppmEntry{{symbol: s, count: 1}}, total: 1, used: p.clock}
+		n.children[symbol], n, p.nodes = child, child, p.nodes+1
+	}
+
+	if len(p.history) < p.Order {
+		p.history = append(p.history, 0)
+	}
+	if len(p.history) > 0 {
+		copy(p.history[1:], p.history)
+		p.history[0] = s
+	}
+}
+
+// prune drops the least recently used contexts, keeping at most half of the budget
+func (p *ppm) prune() {
+	var used []uint64
+	var collect func(n *ppmNode)
+	collect = func(n *ppmNode) {
+		for _, child := range n.children {
+			used = append(used, child.used)
+			collect(child)
+		}
+	}
+	collect(p.root)
+	keep := p.Budget.Nodes/2 - 1
+	if keep < 0 {
+		keep = 0
+	}
+	if len(used) <= keep {
+		return
+	}
+
+	/* a context is used whenever its children are, so dropping the contexts
+	used at or before the cut drops whole subtrees */
+	sort.Slice(used, func(i, j int) bool { return used[i] < used[j] })
+	cut := used[len(used)-keep-1]
+	var drop func(n *ppmNode)
+	drop = func(n *ppmNode) {
+		for s, child := range n.children {
+			if child.used <= cut {
+				delete(n.children, s)
+				continue
+			}
+			drop(child)
+		}
+	}
+	drop(p.root)
+	used = used[:0]
+	collect(p.root)
+	p.nodes = len(used) + 1
+}
+
+// total returns the scale of the current context
+func (p *ppm) total() uint16 {
+	sum, escape := p.scale()
+	return uint16(sum + escape)
+}
+
+// encode codes s, escaping from the contexts that have not seen it, and returns false if emit does
+func (p *ppm) encode(s uint16, emit func(Symbol) bool) bool {
+	for {
+		if p.level < 0 {
+			/* the rank of s among the symbols that are not excluded */
+			low := 0
+			for symbol := 0; symbol < int(s); symbol++ {
+				if p.excluded[symbol] != p.stamp {
+					low++
+				}
+			}
+			return emit(Symbol{Scale: uint16(p.size - p.count), Low: uint16(low), High: uint16(low + 1)})
+		}
+
+		low, high, sum, escape := 0, 0, 0, 0
+		for _, entry := range p.chain[p.level].entries {
+			if p.excluded[entry.symbol] == p.stamp {
+				continue
+			}
+			if entry.symbol == s {
+				low, high = sum, sum+p.frequency(entry.count)
+			}
+			sum, escape = sum+p.frequency(entry.count), escape+1
+		}
+		if high > 0 {
+			return emit(Symbol{Scale: uint16(sum + escape), Low: uint16(low), High: uint16(high)})
+		}
+		if !emit(Symbol{Scale: uint16(sum + escape), Low: uint16(sum), High: uint16(sum + escape)}) {
+			return false
+		}
+		p.exclude()
+		p.next()
+	}
+}
+
+// decode decodes the symbol or the escape of code in the current context, moving to the next context after
+// an escape
+func (p *ppm) decode(code uint16) (low, high int, s uint16, ok bool) {
+	sum, escape := p.scale()
+	if c := int(code); p.level < 0 {
+		if c >= sum {
+			corrupt("ppm decoder", uint32(code))
+		}
+		for symbol := range p.excluded {
+			if p.excluded[symbol] == p.stamp {
+				continue
+			}
+			if c == 0 {
+				return int(code), int(code) + 1, uint16(symbol), true
+			}
+			c--
+		}
+	} else if c >= sum {
+		if c >= sum+escape {
+			corrupt("ppm decoder", uint32(code))
+		}
+		p.exclude()
+		p.next()
+		return sum, sum + escape, 0, false
+	} else {
+		for _, entry := range p.chain[p.level].entries {
+			if p.excluded[entry.symbol] == p.stamp {
+				continue
+			}
+			if high = low + p.frequency(entry.count); c < high {
+				return low, high, entry.symbol, true
+			}
+			low = high
+		}
+	}
+	panic("unreachable")
+}
+
+// PPMCoder codes each symbol with prediction by partial matching, which
+// compresses text well without a transform
+func (coder Coder16) PPMCoder(config PPM) Model {
+	p := newPPM(config, int(coder.Alphabit))
+	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
+
+	go func() {
+		defer close(out)
+		cancel := done(coder.Context)
+
+		buffer := [BUFFER_POOL_SIZE]Symbol{}
+		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
+		emit := func(symbol Symbol) bool {
+			current[index], index = symbol, index+1
+			if index == BUFFER_SIZE {
+				select {
+				case out <- current:
+				case <-cancel:
+					return false
+				}
+				next := offset + BUFFER_SIZE
+				current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
+			}
+			return true
+		}
+
+		for input := range coder.Input {
+			for _, s := range input {
+				p.find()
+				p.start(-1)
+				if !p.encode(s, emit) {
+					return
+				}
+				p.update(s)
+			}
+		}
+
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
+	}()
+
+	return Model{Input: out, Context: coder.Context}
+}
+
+func (decoder Coder16) PPMDecoder(config PPM) Model {
+	p := newPPM(config, int(decoder.Alphabit))
+	p.find()
+	p.start(-1)
+
+	lookup := func(code uint16) Symbol {
+		low, high, s, ok := p.decode(code)
+		if ok {
+			p.update(s)
+			if decoder.Output(s) {
+				return Symbol{}
+			}
+			p.find()
+			p.start(-1)
+		}
+		return Symbol{Scale: p.total(), Low: uint16(low), High: uint16(high)}
+	}
+
+	return Model{Scale: uint32(p.total()), Output: lookup, Context: decoder.Context}
+}
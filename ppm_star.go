@@ -0,0 +1,348 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	PPM_STAR_MIN_WINDOW = 1 << 8
+	PPM_STAR_MAX_WINDOW = 1 << 22
+	/* the hits of deterministic contexts are counted by length, by whether the last one hit and by how
+	confident the longest PPM context is of the predicted symbol */
+	PPM_STAR_LENGTHS     = 32
+	PPM_STAR_CONFIDENCES = 4
+	ppmStarOpen          = math.MaxInt32
+)
+
+// PPMStar configures PPM*, which predicts from contexts of any length. The
+// symbols are kept in a suffix tree, which finds the longest context that
+// occurred before. When it is longer than Order and was always followed by
+// the same symbol, whether that symbol follows again is coded first; if not,
+// or if there is no such context, the symbol is coded with the PPM model,
+// excluding the predicted symbol. The suffix tree holds at most the last
+// Window symbols: when it fills, it is rebuilt from the last half of them.
+type PPMStar struct {
+	PPM
+	Window int
+}
+
+type starEdge struct {
+	first, last, end int32
+}
+
+// starTree is a suffix tree of the symbols built online as by Ukkonen, like
+// SuffixTree is built for bytes. The active point, origin followed by the
+// symbols first through last, is the longest suffix of the symbols that also
+// occurred before. Leaves are open, ending with the symbols.
+type starTree struct {
+	text                []uint16
+	edges               []starEdge
+	index               map[uint64]int32
+	links, depths       []int32
+	children            []int32
+	only                []uint16
+	origin, first, last int32
+}
+
+func newStarTree(window int) *starTree {
+	t := &starTree{text: make([]uint16, 0, window)}
+	t.reset()
+	return t
+}
+
+func (t *starTree) reset() {
+	t.edges, t.index = t.edges[:0], make(map[uint64]int32)
+	t.links, t.depths, t.children, t.only = append(t.links[:0], 0), append(t.depths[:0], 0),
+		append(t.children[:0], 0), append(t.only[:0], 0)
+	t.origin, t.first, t.last = 0, 0, -1
+}
+
+func (t *starTree) edge(node int32, s uint16) (int32, bool) {
+	e, ok := t.index[uint64(node)<<16|uint64(s)]
+	return e, ok
+}
+
+func (t *starTree) put(node int32, edge starEdge) int32 {
+	t.edges = append(t.edges, edge)
+	e := int32(len(t.edges) - 1)
+	t.index[uint64(node)<<16|uint64(t.text[edge.first])] = e
+	return e
+}
+
+// split splits the edge e of the active point after span+1 symbols, returning the new node
+func (t *starTree) split(e, span int32) int32 {
+	edge, node := t.edges[e], int32(len(t.links))
+	t.links, t.depths = append(t.links, t.origin), append(t.depths, t.depths[t.origin]+span+1)
+	t.children, t.only = append(t.children, 1), append(t.only, t.text[edge.first+span+1])
+	t.put(t.origin, starEdge{first: edge.first, last: edge.first + span, end: node})
+	edge.first += span + 1
+	t.edges[e] = edge
+	t.index[uint64(node)<<16|uint64(t.text[edge.first])] = e
+	return node
+}
+
+// canonize moves the origin of the active point down the edges it spans
+func (t *starTree) canonize() {
+	if t.first > t.last {
+		return
+	}
+	e, _ := t.edge(t.origin, t.text[t.first])
+	edge := t.edges[e]
+	for span := edge.last - edge.first; span <= t.last-t.first; span = edge.last - edge.first {
+		t.first += span + 1
+		t.origin = edge.end
+		if t.first > t.last {
+			break
+		}
+		e, _ = t.edge(t.origin, t.text[t.first])
+		edge = t.edges[e]
+	}
+}
+
+// add adds s to the tree, rebuilding it from the last half of the symbols once it is full
+func (t *starTree) add(s uint16) {
+	if len(t.text) == cap(t.text) {
+		kept := t.text[len(t.text)/2:]
+		t.text = t.text[:0]
+		t.reset()
+		for _, symbol := range kept {
+			t.add(symbol)
+		}
+	}
+
+	t.text = append(t.text, s)
+	i, last, parent := int32(len(t.text)-1), int32(-1), int32(0)
+	for {
+		parent = t.origin
+		if t.first <= t.last {
+			e, _ := t.edge(t.origin, t.text[t.first])
+			span := t.last - t.first
+			if t.text[t.edges[e].first+span+1] == s {
+				break
+			}
+			parent = t.split(e, span)
+		} else if _, ok := t.edge(t.origin, s); ok {
+			break
+		}
+
+		t.put(parent, starEdge{first: i, last: ppmStarOpen, end: -1})
+		if t.children[parent]++; t.children[parent] == 1 {
+			t.only[parent] = s
+		}
+		if last > 0 {
+			t.links[last] = parent
+		}
+		last = parent
+		if t.origin == 0 {
+			t.first++
+		} else {
+			t.origin = t.links[t.origin]
+		}
+		t.canonize()
+	}
+	if last > 0 {
+		t.links[last] = parent
+	}
+	t.last++
+	t.canonize()
+}
+
+// predict returns the length of the longest context that occurred before
+// and, if it was always followed by the same symbol, that symbol
+func (t *starTree) predict() (s uint16, length int, deterministic bool) {
+	length = int(t.depths[t.origin])
+	if t.first <= t.last {
+		e, _ := t.edge(t.origin, t.text[t.first])
+		i := t.edges[e].first + t.last - t.first + 1
+		length += int(t.last - t.first + 1)
+		if int(i) >= len(t.text) {
+			return 0, length, false
+		}
+		return t.text[i], length, true
+	}
+	return t.only[t.origin], length, t.children[t.origin] == 1
+}
+
+// ppmStar adds the suffix tree and the counts of the hits of the deterministic contexts to the PPM model
+type ppmStar struct {
+	*ppm
+	tree          *starTree
+	hits          []counter
+	hit           int
+	slot          *counter
+	predicted     uint16
+	deterministic bool
+}
+
+func checkPPMStar(p PPMStar, size int) {
+	checkPPM(p.PPM, size)
+	if p.Window < PPM_STAR_MIN_WINDOW || p.Window > PPM_STAR_MAX_WINDOW {
+		panic(fmt.Sprintf("compress: PPM* window of %d symbols is not in %d..%d",
+			p.Window, PPM_STAR_MIN_WINDOW, PPM_STAR_MAX_WINDOW))
+	}
+}
+
+func newPPMStar(p PPMStar, size int) *ppmStar {
+	checkPPMStar(p, size)
+	return &ppmStar{ppm: newPPM(p.PPM, size), tree: newStarTree(p.Window),
+		hits: newCounters(2 * PPM_STAR_LENGTHS * PPM_STAR_CONFIDENCES)}
+}
+
+// find finds the contexts of the next symbol, returning the probability that a deterministic context, if
+// any, predicts it
+func (p *ppmStar) find() uint16 {
+	p.ppm.find()
+	predicted, length, deterministic := p.tree.predict()
+	if p.deterministic = deterministic && length > p.Order; !p.deterministic {
+		p.start(-1)
+		return 0
+	}
+
+	if length >= 16 {
+		length = 12 + bits.Len(uint(length))
+		if length >= PPM_STAR_LENGTHS {
+			length = PPM_STAR_LENGTHS - 1
+		}
+	}
+	confidence, n := 0, p.chain[len(p.chain)-1]
+	for _, entry := range n.entries {
+		if entry.symbol == predicted {
+			if confidence = 1 + 3*p.frequency(entry.count)/(2*n.total+1); confidence >= PPM_STAR_CONFIDENCES {
+				confidence = PPM_STAR_CONFIDENCES - 1
+			}
+			break
+		}
+	}
+	p.predicted, p.slot = predicted, &p.hits[(2*length+p.hit)*PPM_STAR_CONFIDENCES+confidence]
+	if p1 := p.slot.p >> 4; p1 < 1 {
+		return 1
+	} else if p1 > mixingScale-1 {
+		return mixingScale - 1
+	} else {
+		return p1
+	}
+}
+
+// miss counts a miss of the deterministic context and excludes its symbol from the PPM model
+func (p *ppmStar) miss() {
+	p.slot.update(0)
+	p.hit = 0
+	p.start(int(p.predicted))
+}
+
+// update counts s in the PPM model and adds it to the suffix tree
+func (p *ppmStar) update(s uint16, hit bool) {
+	if hit {
+		p.slot.update(1)
+		p.hit = 1
+		p.locate(s)
+	}
+	p.ppm.update(s)
+	p.tree.add(s)
+}
+
+// PPMStarCoder codes each symbol with PPM*, which pays off on highly repetitive input
+func (coder Coder16) PPMStarCoder(config PPMStar) Model {
+	p := newPPMStar(config, int(coder.Alphabit))
+	out := make(chan []Symbol, BUFFER_CHAN_SIZE)
+
+	go func() {
+		defer close(out)
+		cancel := done(coder.Context)
+
+		buffer := [BUFFER_POOL_SIZE]Symbol{}
+		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
+		emit := func(symbol Symbol) bool {
+			current[index], index = symbol, index+1
+			if index == BUFFER_SIZE {
+				select {
+				case out <- current:
+				case <-cancel:
+					return false
+				}
+				next := offset + BUFFER_SIZE
+				current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
+			}
+			return true
+		}
+
+		const scale = uint16(mixingScale)
+		for input := range coder.Input {
+			for _, s := range input {
+				p1, hit := p.find(), false
+				if p.deterministic {
+					zero := scale - p1
+					if hit = s == p.predicted; hit {
+						if !emit(Symbol{Scale: scale, Low: zero, High: scale}) {
+							return
+						}
+					} else {
+						if !emit(Symbol{Scale: scale, Low: 0, High: zero}) {
+							return
+						}
+						p.miss()
+					}
+				}
+				if !hit && !p.encode(s, emit) {
+					return
+				}
+				p.update(s, hit)
+			}
+		}
+
+		select {
+		case out <- current[:index]:
+		case <-cancel:
+		}
+	}()
+
+	return Model{Input: out, Context: coder.Context}
+}
+
+func (decoder Coder16) PPMStarDecoder(config PPMStar) Model {
+	const scale = uint16(mixingScale)
+	p := newPPMStar(config, int(decoder.Alphabit))
+	zero, flag := scale-p.find(), p.deterministic
+	next := func() uint16 {
+		if zero, flag = scale-p.find(), p.deterministic; flag {
+			return scale
+		}
+		return p.total()
+	}
+
+	lookup := func(code uint16) Symbol {
+		var low, high int
+		var s uint16
+		hit := false
+		if flag {
+			if flag = false; code < zero {
+				p.miss()
+				return Symbol{Scale: p.total(), Low: 0, High: zero}
+			}
+			low, high, s, hit = int(zero), int(scale), p.predicted, true
+		} else {
+			var ok bool
+			if low, high, s, ok = p.decode(code); !ok {
+				return Symbol{Scale: p.total(), Low: uint16(low), High: uint16(high)}
+			}
+		}
+
+		p.update(s, hit)
+		if decoder.Output(s) {
+			return Symbol{}
+		}
+		return Symbol{Scale: next(), Low: uint16(low), High: uint16(high)}
+	}
+
+	initial := uint32(scale)
+	if !flag {
+		initial = uint32(p.total())
+	}
+	return Model{Scale: initial, Output: lookup, Context: decoder.Context}
+}
@@ -0,0 +1,236 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"context"
+	"io"
+)
+
+// Range is a Model coded by a byte oriented range coder with carry
+// propagation, after Subbotin and Schindler, instead of the bitwise
+// arithmetic coder. It renormalizes a byte at a time and buffers its output,
+// so a model switches backends with a conversion: Range(coder.AdaptiveCoder()).
+type Range Model
+
+// Range32 is Range for Model32, whose scales need a 64 bit range
+type Range32 Model32
+
+const (
+	/* the shift of the top byte of the range for Range and Range32 */
+	RANGE_BITS   = 24
+	RANGE_BITS32 = 48
+)
+
+// rangeEncoder keeps the bottom of the interval in low, with a carry bit
+// above its top byte, and the width of the interval in width. The top byte
+// of low is held in cache, followed by pending-1 bytes of 0xff, until it is
+// known whether a carry reaches them.
+type rangeEncoder struct {
+	low, width uint64
+	bits       uint
+	cache      byte
+	pending    int
+	buffer     []byte
+	out        io.Writer
+	count      int
+}
+
+func newRangeEncoder(out io.Writer, bits uint) *rangeEncoder {
+	return &rangeEncoder{width: 1<<(bits+8) - 1, bits: bits, pending: 1, buffer: make([]byte, 0, 1<<12), out: out}
+}
+
+// encode narrows the interval to [low, high), where r is the width of one unit of the scale
+func (e *rangeEncoder) encode(r, low, high uint64) {
+	e.low, e.width = e.low+r*low, r*(high-low)
+	for e.width < 1<<e.bits {
+		e.width <<= 8
+		e.shift()
+	}
+}
+
+// shift moves the top byte out of low, resolving the held bytes once a carry can no longer reach them
+func (e *rangeEncoder) shift() {
+	if e.low < 0xff<<e.bits || e.low >= 1<<(e.bits+8) {
+		carry := byte(e.low >> (e.bits + 8))
+		for b := e.cache; e.pending > 0; b, e.pending = 0xff, e.pending-1 {
+			e.write(b + carry)
+		}
+		e.cache = byte(e.low >> e.bits)
+	}
+	e.pending++
+	e.low = (e.low & (1<<e.bits - 1)) << 8
+}
+
+func (e *rangeEncoder) write(b byte) {
+	if e.buffer = append(e.buffer, b); len(e.buffer) == cap(e.buffer) {
+		e.out.Write(e.buffer)
+		e.buffer = e.buffer[:0]
+	}
+	e.count++
+}
+
+// flush shifts out all of low and returns the number of bits written
+func (e *rangeEncoder) flush() int {
+	for i := uint(0); i < e.bits/8+2; i++ {
+		e.shift()
+	}
+	if len(e.buffer) > 0 {
+		e.out.Write(e.buffer)
+	}
+	return 8 * e.count
+}
+
+// rangeDecoder mirrors rangeEncoder, keeping the code relative to the bottom of the interval
+type rangeDecoder struct {
+	code, width uint64
+	bits        uint
+	in          io.ByteReader
+	cancel      <-chan struct{}
+	ctx         context.Context
+	count       int
+}
+
+func newRangeDecoder(in io.Reader, bits uint, ctx context.Context) *rangeDecoder {
+	d := &rangeDecoder{width: 1<<(bits+8) - 1, bits: bits, cancel: done(ctx), ctx: ctx}
+	if reader, ok := in.(io.ByteReader); ok {
+		d.in = reader
+	} else {
+		/* a byte at a time, so that no input past the end of the range coded data is consumed */
+		d.in = &byteReader{Reader: in}
+	}
+	/* the first byte is the initial cache of the encoder */
+	for i := uint(0); i < bits/8+2; i++ {
+		d.code = (d.code<<8 | d.read()) & (1<<(bits+8) - 1)
+	}
+	return d
+}
+
+func (d *rangeDecoder) read() uint64 {
+	if d.count++; d.count&(BUFFER_SIZE-1) == 0 {
+		select {
+		case <-d.cancel:
+			raise(d.ctx.Err())
+		default:
+		}
+	}
+	b, err := d.in.ReadByte()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		/* the encoder flushes all of low, so the decoder never reads past the end */
+		raise(ErrTruncated)
+	} else if err != nil {
+		raise(err)
+	}
+	return uint64(b)
+}
+
+// find returns the code out of scale, where r is the width of one unit of the scale
+func (d *rangeDecoder) find(r, scale uint64) uint64 {
+	code := d.code / r
+	if code >= scale {
+		corrupt("range decoder", uint32(code))
+	}
+	return code
+}
+
+// decode removes the interval [low, high) found by find
+func (d *rangeDecoder) decode(r, low, high uint64) {
+	d.code, d.width = d.code-r*low, r*(high-low)
+	for d.width < 1<<d.bits {
+		d.code, d.width = (d.code<<8|d.read())&(1<<(d.bits+8)-1), d.width<<8
+	}
+}
+
+func (model Range) Code(out io.Writer) int {
+	e := newRangeEncoder(out, RANGE_BITS)
+	if fixed := model.Fixed; fixed > 0 {
+		for current := range model.Input {
+			for _, s := range current {
+				e.encode(e.width>>fixed, uint64(s.Low), uint64(s.High))
+			}
+		}
+	} else {
+		for current := range model.Input {
+			for _, s := range current {
+				e.encode(e.width/uint64(s.Scale), uint64(s.Low), uint64(s.High))
+			}
+		}
+	}
+	return e.flush()
+}
+
+// Decode decodes symbols from in until the model signals the end of output.
+// ErrTruncated is returned if in runs out of bytes before that.
+func (model Range) Decode(in io.Reader) (err error) {
+	defer recoverDecode(&err)
+
+	d, scale := newRangeDecoder(in, RANGE_BITS, model.Context), uint64(model.Scale)
+	if fixed := model.Fixed; fixed > 0 {
+		scale = 1 << fixed
+	}
+	r := d.width / scale
+	s := model.Output(uint16(d.find(r, scale)))
+	for s.High != 0 {
+		d.decode(r, uint64(s.Low), uint64(s.High))
+		if model.Fixed == 0 {
+			scale = uint64(s.Scale)
+		}
+		r = d.width / scale
+		s = model.Output(uint16(d.find(r, scale)))
+	}
+
+	return nil
+}
+
+// Err returns why the pipeline feeding the model stopped early, or nil
+func (model Range) Err() error {
+	return contextErr(model.Context)
+}
+
+func (model Range32) Code(out io.Writer) int {
+	e := newRangeEncoder(out, RANGE_BITS32)
+	if fixed := model.Fixed; fixed > 0 {
+		for current := range model.Input {
+			for _, s := range current {
+				e.encode(e.width>>fixed, uint64(s.Low), uint64(s.High))
+			}
+		}
+	} else {
+		for current := range model.Input {
+			for _, s := range current {
+				e.encode(e.width/uint64(s.Scale), uint64(s.Low), uint64(s.High))
+			}
+		}
+	}
+	return e.flush()
+}
+
+// Decode decodes symbols from in until the model signals the end of output.
+// ErrTruncated is returned if in runs out of bytes before that.
+func (model Range32) Decode(in io.Reader) (err error) {
+	defer recoverDecode(&err)
+
+	d, scale := newRangeDecoder(in, RANGE_BITS32, model.Context), model.Scale
+	if fixed := model.Fixed; fixed > 0 {
+		scale = 1 << fixed
+	}
+	r := d.width / scale
+	s := model.Output(uint32(d.find(r, scale)))
+	for s.High != 0 {
+		d.decode(r, uint64(s.Low), uint64(s.High))
+		if model.Fixed == 0 {
+			scale = uint64(s.Scale)
+		}
+		r = d.width / scale
+		s = model.Output(uint32(d.find(r, scale)))
+	}
+
+	return nil
+}
+
+// Err returns why the pipeline feeding the model stopped early, or nil
+func (model Range32) Err() error {
+	return contextErr(model.Context)
+}
@@ -0,0 +1,770 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Args are the non-negative integer arguments of a stage in a pipeline spec,
+// such as depth=2 in cdf16(depth=2). The Args of a registered stage are its
+// defaults and name every argument that it accepts.
+type Args map[string]int
+
+// Encoder is the last stage of a compression pipeline
+type Encoder interface {
+	Code(out io.Writer) int
+}
+
+// Decoder is the first stage of a decompression pipeline
+type Decoder interface {
+	Decode(in io.Reader) error
+}
+
+// Transform is a stage that rewrites blocks of bytes, such as a Burrows-Wheeler
+// transform. Check, if set, rejects invalid arguments, as it does for the
+// other kinds of stages.
+type Transform struct {
+	Name    string
+	Args    Args
+	Check   func(args Args) error
+	Coder   func(ctx context.Context, input <-chan []byte, args Args) Coder8
+	Decoder func(ctx context.Context, output <-chan []byte, args Args) Coder8
+}
+
+// Mapping is a stage that turns bytes into symbols for an entropy coder
+type Mapping struct {
+	Name     string
+	Args     Args
+	Check    func(args Args) error
+	Alphabit uint16
+	Coder    func(coder Coder8, args Args) Coder16
+	Decoder  func(decoder Coder8, args Args) Coder16
+}
+
+// Entropy is the stage that models symbols and codes them. Alphabit is the
+// largest alphabet the stage supports; zero means any.
+type Entropy struct {
+	Name     string
+	Args     Args
+	Check    func(args Args) error
+	Alphabit uint16
+	Coder    func(coder Coder16, args Args) Encoder
+	Decoder  func(decoder Coder16, args Args) Decoder
+}
+
+var registry = struct {
+	sync.RWMutex
+	transforms map[string]*Transform
+	mappings   map[string]*Mapping
+	entropies  map[string]*Entropy
+}{
+	transforms: make(map[string]*Transform),
+	mappings:   make(map[string]*Mapping),
+	entropies:  make(map[string]*Entropy),
+}
+
+func checkStage(name string, registered bool) {
+	if name == "" || strings.ContainsAny(name, "|(),= ") {
+		panic("compress: invalid stage name " + strconv.Quote(name))
+	}
+	if registered {
+		panic("compress: stage " + name + " registered twice")
+	}
+}
+
+// RegisterTransform makes a transform available to ParseSpec; it panics if the name is taken
+func RegisterTransform(transform Transform) {
+	registry.Lock()
+	defer registry.Unlock()
+	_, registered := registry.transforms[transform.Name]
+	checkStage(transform.Name, registered)
+	registry.transforms[transform.Name] = &transform
+}
+
+// RegisterMapping makes a mapping available to ParseSpec; it panics if the name is taken
+func RegisterMapping(mapping Mapping) {
+	registry.Lock()
+	defer registry.Unlock()
+	_, registered := registry.mappings[mapping.Name]
+	checkStage(mapping.Name, registered)
+	registry.mappings[mapping.Name] = &mapping
+}
+
+// RegisterEntropy makes an entropy coder available to ParseSpec; it panics if the name is taken
+func RegisterEntropy(entropy Entropy) {
+	registry.Lock()
+	defer registry.Unlock()
+	_, registered := registry.entropies[entropy.Name]
+	checkStage(entropy.Name, registered)
+	registry.entropies[entropy.Name] = &entropy
+}
+
+// Stages returns every registered stage with its default arguments, transforms
+// first, then mappings, then entropy coders, each sorted by name
+func Stages() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	var stages, names []string
+	add := func(kind string, args func(name string) Args) {
+		sort.Strings(names)
+		for _, name := range names {
+			stages = append(stages, kind+" "+formatStage(name, args(name)))
+		}
+		names = names[:0]
+	}
+	for name := range registry.transforms {
+		names = append(names, name)
+	}
+	add("transform", func(name string) Args { return registry.transforms[name].Args })
+	for name := range registry.mappings {
+		names = append(names, name)
+	}
+	add("mapping", func(name string) Args { return registry.mappings[name].Args })
+	for name := range registry.entropies {
+		names = append(names, name)
+	}
+	add("entropy", func(name string) Args { return registry.entropies[name].Args })
+	return stages
+}
+
+func formatStage(name string, args Args) string {
+	if len(args) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = key + "=" + strconv.Itoa(args[key])
+	}
+	return name + "(" + strings.Join(keys, ",") + ")"
+}
+
+// parseStage splits a stage such as cdf16(depth=2) into its name and
+// arguments, filling in the defaults
+func parseStage(stage string, defaults func(name string) (Args, bool)) (string, Args, error) {
+	name, list := strings.TrimSpace(stage), ""
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		if !strings.HasSuffix(name, ")") {
+			return "", nil, fmt.Errorf("compress: missing ) in stage %q", stage)
+		}
+		name, list = strings.TrimSpace(name[:i]), name[i+1:len(name)-1]
+	}
+	allowed, ok := defaults(name)
+	if !ok {
+		return "", nil, fmt.Errorf("compress: unknown stage %q", name)
+	}
+
+	args := make(Args, len(allowed))
+	for key, value := range allowed {
+		args[key] = value
+	}
+	if strings.TrimSpace(list) == "" {
+		return name, args, nil
+	}
+	for _, arg := range strings.Split(list, ",") {
+		i := strings.IndexByte(arg, '=')
+		if i < 0 {
+			return "", nil, fmt.Errorf("compress: argument %q of stage %s is not key=value", arg, name)
+		}
+		key := strings.TrimSpace(arg[:i])
+		if _, ok := allowed[key]; !ok {
+			return "", nil, fmt.Errorf("compress: unknown argument %q of stage %s", key, name)
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(arg[i+1:]))
+		if err != nil || value < 0 {
+			return "", nil, fmt.Errorf("compress: argument %s of stage %s is not a non-negative integer", key, name)
+		}
+		args[key] = value
+	}
+	return name, args, nil
+}
+
+// Codec is a matched compressor and decompressor built from a pipeline spec
+type Codec struct {
+	transform  *Transform
+	mapping    *Mapping
+	entropy    *Entropy
+	args       [3]Args
+	dictionary *Dictionary
+}
+
+// ParseSpec builds the codec for a spec of the form [transform|]mapping|entropy,
+// where each stage is a registered name optionally followed by arguments,
+// for example bbwt|mtf-rle|cdf16(depth=2)
+func ParseSpec(spec string) (*Codec, error) {
+	stages := strings.Split(spec, "|")
+	if len(stages) < 2 || len(stages) > 3 {
+		return nil, fmt.Errorf("compress: pipeline spec %q is not [transform|]mapping|entropy", spec)
+	}
+
+	registry.RLock()
+	defer registry.RUnlock()
+	codec, err := &Codec{}, error(nil)
+	if len(stages) == 3 {
+		var name string
+		name, codec.args[0], err = parseStage(stages[0], func(name string) (Args, bool) {
+			transform, ok := registry.transforms[name]
+			if ok {
+				return transform.Args, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		codec.transform, stages = registry.transforms[name], stages[1:]
+		if err := check(name, codec.transform.Check, codec.args[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	name, args, err := parseStage(stages[0], func(name string) (Args, bool) {
+		mapping, ok := registry.mappings[name]
+		if ok {
+			return mapping.Args, true
+		}
+		return nil, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	codec.mapping, codec.args[1] = registry.mappings[name], args
+	if err := check(name, codec.mapping.Check, args); err != nil {
+		return nil, err
+	}
+
+	name, args, err = parseStage(stages[1], func(name string) (Args, bool) {
+		entropy, ok := registry.entropies[name]
+		if ok {
+			return entropy.Args, true
+		}
+		return nil, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	codec.entropy, codec.args[2] = registry.entropies[name], args
+	if err := check(name, codec.entropy.Check, args); err != nil {
+		return nil, err
+	}
+
+	if alphabit := codec.entropy.Alphabit; alphabit != 0 && codec.mapping.Alphabit > alphabit {
+		return nil, fmt.Errorf("compress: stage %s supports %d symbols; %s produces %d",
+			codec.entropy.Name, alphabit, codec.mapping.Name, codec.mapping.Alphabit)
+	}
+	return codec, nil
+}
+
+// check runs the Check of stage name on its arguments
+func check(name string, check func(args Args) error, args Args) error {
+	if check == nil {
+		return nil
+	}
+	if err := check(args); err != nil {
+		return fmt.Errorf("compress: stage %s: %v", name, err)
+	}
+	return nil
+}
+
+// String returns the canonical spec of the codec with every argument spelled out
+func (c *Codec) String() string {
+	spec := formatStage(c.mapping.Name, c.args[1]) + "|" + formatStage(c.entropy.Name, c.args[2])
+	if c.transform != nil {
+		spec = formatStage(c.transform.Name, c.args[0]) + "|" + spec
+	}
+	return spec
+}
+
+// WithDictionary returns a copy of the codec whose models are primed with
+// dictionary before each block. Entropy stages that count their input
+// before coding it, such as rans, tans and huffman, return ErrPrime.
+func (c *Codec) WithDictionary(dictionary *Dictionary) (*Codec, error) {
+	if dictionary != nil && !dictionary.primer(c).primed {
+		return nil, fmt.Errorf("%w: %s", ErrPrime, c.entropy.Name)
+	}
+	codec := *c
+	codec.dictionary = dictionary
+	return &codec, nil
+}
+
+// Coder returns the compression pipeline over the blocks of input, which may
+// be modified in place
+func (c *Codec) Coder(ctx context.Context, input <-chan []byte) Encoder {
+	coder := Coder8{Alphabit: 256, Input: input, Context: ctx}
+	if c.transform != nil {
+		coder = c.transform.Coder(ctx, input, c.args[0])
+	}
+	mapped := c.mapping.Coder(coder, c.args[1])
+	entropy := func(coder Coder16) Encoder {
+		return c.entropy.Coder(coder, c.args[2])
+	}
+	if c.dictionary != nil {
+		return c.dictionary.primer(c).prime(mapped, entropy)
+	}
+	return entropy(mapped)
+}
+
+// Decoder returns the decompression pipeline that fills the blocks of output
+func (c *Codec) Decoder(ctx context.Context, output <-chan []byte) Decoder {
+	var decoder Coder8
+	if c.transform != nil {
+		decoder = c.transform.Decoder(ctx, output, c.args[0])
+	} else {
+		decoder = identityDecoder(ctx, output)
+	}
+	mapped := c.mapping.Decoder(decoder, c.args[1])
+	entropy := func(decoder Coder16) Decoder {
+		return c.entropy.Decoder(decoder, c.args[2])
+	}
+	if c.dictionary != nil {
+		return c.dictionary.primer(c).primeDecoder(mapped, entropy)
+	}
+	return entropy(mapped)
+}
+
+// Compress compresses input as a single block and writes it to output
+func (c *Codec) Compress(input []byte, output io.Writer) {
+	data, channel := make([]byte, len(input)), make(chan []byte, 1)
+	copy(data, input)
+	channel <- data
+	close(channel)
+	c.Coder(nil, channel).Code(output)
+}
+
+// Decompress decompresses input written by Compress into output, which must
+// have the length of the original data
+func (c *Codec) Decompress(input io.Reader, output []byte) error {
+	channel := make(chan []byte, 1)
+	channel <- output
+	close(channel)
+	return c.Decoder(nil, channel).Decode(input)
+}
+
+func identityDecoder(ctx context.Context, output <-chan []byte) Coder8 {
+	buffer, i, next := []byte(nil), 0, blocks(ctx, output)
+	add := func(symbol uint8) bool {
+		for len(buffer) == 0 {
+			block, ok := next()
+			if !ok {
+				return true
+			}
+			buffer = block
+		}
+
+		buffer[i], i = symbol, i+1
+		if i == len(buffer) {
+			block, ok := next()
+			if !ok {
+				return true
+			}
+			buffer, i = block, 0
+		}
+		return false
+	}
+
+	return Coder8{Alphabit: 256, Output: add, Context: ctx}
+}
+
+// IdentityCoder passes bytes through as 16 bit symbols
+func (coder Coder8) IdentityCoder() Coder16 {
+	symbols := make(chan []uint16, BUFFER_CHAN_SIZE)
+
+	go func() {
+		defer close(symbols)
+		cancel := done(coder.Context)
+
+		buffer := [BUFFER_POOL_SIZE]uint16{}
+		current, offset, index := buffer[0:BUFFER_SIZE], BUFFER_SIZE, 0
+		for block, ok := receive(coder.Input, cancel); ok; block, ok = receive(coder.Input, cancel) {
+			for _, v := range block {
+				current[index], index = uint16(v), index+1
+				if index == BUFFER_SIZE {
+					select {
+					case symbols <- current:
+					case <-cancel:
+						return
+					}
+					next := offset + BUFFER_SIZE
+					current, offset, index = buffer[offset:next], next&BUFFER_POOL_SIZE_MASK, 0
+				}
+			}
+		}
+
+		select {
+		case symbols <- current[:index]:
+		case <-cancel:
+		}
+	}()
+
+	return Coder16{Alphabit: 256, Input: symbols, Context: coder.Context}
+}
+
+// IdentityDecoder is the inverse of IdentityCoder
+func (coder Coder8) IdentityDecoder() Coder16 {
+	output := func(symbol uint16) bool {
+		if symbol > 255 {
+			corrupt("identity decoder", uint32(symbol))
+		}
+		return coder.Output(uint8(symbol))
+	}
+
+	return Coder16{Alphabit: 256, Output: output, Context: coder.Context}
+}
+
+func init() {
+	RegisterTransform(Transform{
+		Name: "bwt",
+		Coder: func(ctx context.Context, input <-chan []byte, args Args) Coder8 {
+			return BurrowsWheelerInlineCoderContext(ctx, input)
+		},
+		Decoder: func(ctx context.Context, output <-chan []byte, args Args) Coder8 {
+			return BurrowsWheelerInlineDecoderContext(ctx, output)
+		},
+	})
+	RegisterTransform(Transform{
+		Name: "bbwt",
+		Coder: func(ctx context.Context, input <-chan []byte, args Args) Coder8 {
+			return BijectiveBurrowsWheelerCoderContext(ctx, input)
+		},
+		Decoder: func(ctx context.Context, output <-chan []byte, args Args) Coder8 {
+			return BijectiveBurrowsWheelerDecoderContext(ctx, output)
+		},
+	})
+
+	RegisterMapping(Mapping{
+		Name:     "identity",
+		Alphabit: 256,
+		Coder:    func(coder Coder8, args Args) Coder16 { return coder.IdentityCoder() },
+		Decoder:  func(decoder Coder8, args Args) Coder16 { return decoder.IdentityDecoder() },
+	})
+	RegisterMapping(Mapping{
+		Name:     "mtf",
+		Alphabit: 256,
+		Coder:    func(coder Coder8, args Args) Coder16 { return coder.MoveToFrontCoder() },
+		Decoder:  func(decoder Coder8, args Args) Coder16 { return decoder.MoveToFrontDecoder() },
+	})
+	RegisterMapping(Mapping{
+		Name:     "mtf-rle",
+		Alphabit: 257,
+		Coder:    func(coder Coder8, args Args) Coder16 { return coder.MoveToFrontRunLengthCoder() },
+		Decoder:  func(decoder Coder8, args Args) Coder16 { return decoder.MoveToFrontRunLengthDecoder() },
+	})
+
+	entropy := func(name string, coder func(Coder16) Model, decoder func(Coder16) Model) {
+		RegisterEntropy(Entropy{
+			Name:    name,
+			Coder:   func(c Coder16, args Args) Encoder { return coder(c) },
+			Decoder: func(d Coder16, args Args) Decoder { return decoder(d) },
+		})
+	}
+	entropy("adaptive", Coder16.AdaptiveCoder, Coder16.AdaptiveDecoder)
+	entropy("adaptive-predictive", Coder16.AdaptivePredictiveCoder, Coder16.AdaptivePredictiveDecoder)
+	entropy("adaptive-bit", Coder16.AdaptiveBitCoder, Coder16.AdaptiveBitDecoder)
+	entropy("adaptive-predictive-bit", Coder16.AdaptivePredictiveBitCoder, Coder16.AdaptivePredictiveBitDecoder)
+	entropy("filtered-adaptive-bit", Coder16.FilteredAdaptiveBitCoder, Coder16.FilteredAdaptiveBitDecoder)
+	entropy("filtered-adaptive-predictive-bit", Coder16.FilteredAdaptivePredictiveBitCoder,
+		Coder16.FilteredAdaptivePredictiveBitDecoder)
+	entropy("mixing-bit", Coder16.MixingBitCoder, Coder16.MixingBitDecoder)
+	sse := func(name string, predictor func(alphabit uint16) BitPredictor) {
+		RegisterEntropy(Entropy{
+			Name:  "sse-" + name,
+			Args:  Args{"order": 0, "rate": SSE_RATE},
+			Check: checkSSEArgs,
+			Coder: func(c Coder16, args Args) Encoder {
+				return c.SSEBitCoder(predictor(c.Alphabit), args["order"], args["rate"])
+			},
+			Decoder: func(d Coder16, args Args) Decoder {
+				return d.SSEBitDecoder(predictor(d.Alphabit), args["order"], args["rate"])
+			},
+		})
+	}
+	sse("adaptive-bit", func(uint16) BitPredictor { return NewAdaptiveBitPredictor() })
+	sse("adaptive-predictive-bit", func(uint16) BitPredictor { return NewAdaptivePredictiveBitPredictor() })
+	sse("filtered-adaptive-bit", func(uint16) BitPredictor { return NewFilteredAdaptiveBitPredictor() })
+	sse("filtered-adaptive-predictive-bit", func(uint16) BitPredictor { return NewFilteredAdaptivePredictiveBitPredictor() })
+	sse("mixing-bit", NewMixingBitPredictor)
+	sse("ctw-bit", func(uint16) BitPredictor { return NewCTWBitPredictor(CTW_DEPTH) })
+	RegisterEntropy(Entropy{
+		Name:  "ctw-bit",
+		Args:  Args{"depth": CTW_DEPTH},
+		Check: checkCTWArgs,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.CTWBitCoder(args["depth"])
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.CTWBitDecoder(args["depth"])
+		},
+	})
+	RegisterEntropy(Entropy{
+		Name:     "cdf16",
+		Args:     Args{"depth": 2, "nodes": 0, "policy": int(PolicyReset), "hashed": 0},
+		Check:    checkCDFArgs,
+		Alphabit: CDF16MaxSize,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.FilteredAdaptiveCoder(NewBoundedCDF16(args["depth"], false, budget(args)))
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.FilteredAdaptiveDecoder(NewBoundedCDF16(args["depth"], false, budget(args)))
+		},
+	})
+	RegisterEntropy(Entropy{
+		Name:     "ppm",
+		Args:     Args{"order": 4, "method": int(PPMD), "nodes": 0, "policy": int(PolicyReset)},
+		Check:    checkPPMArgs,
+		Alphabit: PPMMaxSize,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.PPMCoder(PPM{Order: args["order"], Method: PPMMethod(args["method"]), Budget: budget(args)})
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.PPMDecoder(PPM{Order: args["order"], Method: PPMMethod(args["method"]), Budget: budget(args)})
+		},
+	})
+	RegisterEntropy(Entropy{
+		Name:     "ppm-star",
+		Args:     Args{"order": 4, "method": int(PPMD), "nodes": 0, "policy": int(PolicyReset), "window": 1 << 18},
+		Check:    checkPPMStarArgs,
+		Alphabit: PPMMaxSize,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.PPMStarCoder(PPMStar{
+				PPM:    PPM{Order: args["order"], Method: PPMMethod(args["method"]), Budget: budget(args)},
+				Window: args["window"],
+			})
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.PPMStarDecoder(PPMStar{
+				PPM:    PPM{Order: args["order"], Method: PPMMethod(args["method"]), Budget: budget(args)},
+				Window: args["window"],
+			})
+		},
+	})
+	RegisterEntropy(Entropy{
+		Name:     "fenwick",
+		Args:     Args{"increment": 1},
+		Check:    checkIncrement(MAX_SCALE16 - 2*FenwickMaxSize),
+		Alphabit: FenwickMaxSize,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.FenwickCoder(args["increment"])
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.FenwickDecoder(args["increment"])
+		},
+	})
+
+	entropy32 := func(name string, coder func(Coder16) Model32, decoder func(Coder16) Model32) {
+		RegisterEntropy(Entropy{
+			Name:    name,
+			Coder:   func(c Coder16, args Args) Encoder { return coder(c) },
+			Decoder: func(d Coder16, args Args) Decoder { return decoder(d) },
+		})
+	}
+	entropy32("adaptive32", Coder16.AdaptiveCoder32, Coder16.AdaptiveDecoder32)
+	entropy32("adaptive-predictive32", Coder16.AdaptivePredictiveCoder32, Coder16.AdaptivePredictiveDecoder32)
+	RegisterEntropy(Entropy{
+		Name:  "cdf32",
+		Args:  Args{"depth": 2, "nodes": 0, "policy": int(PolicyReset), "hashed": 0},
+		Check: checkCDFArgs,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.FilteredAdaptiveCoder32(NewBoundedCDF32(args["depth"], false, budget(args)))
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.FilteredAdaptiveDecoder32(NewBoundedCDF32(args["depth"], false, budget(args)))
+		},
+	})
+	RegisterEntropy(Entropy{
+		Name:  "fenwick32",
+		Args:  Args{"increment": 1},
+		Check: checkIncrement(MAX_SCALE32 - 2*(1<<16)),
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.FenwickCoder32(args["increment"])
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.FenwickDecoder32(args["increment"])
+		},
+	})
+	/* every entropy coder is also available with the range coder as its backend */
+	registry.RLock()
+	entropies := make([]Entropy, 0, len(registry.entropies))
+	for _, entropy := range registry.entropies {
+		entropies = append(entropies, *entropy)
+	}
+	registry.RUnlock()
+	for _, entropy := range entropies {
+		RegisterEntropy(ranged(entropy))
+	}
+
+	/* the asymmetric numeral system coders count the symbols instead of modeling them */
+	RegisterEntropy(Entropy{
+		Name:  "rans",
+		Args:  Args{"block": 0, "log": 12, "states": 4},
+		Check: checkANSArgs,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.RANSCoder(ANS{Block: args["block"], Log: args["log"], States: args["states"]})
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.RANSDecoder(ANS{Block: args["block"], Log: args["log"], States: args["states"]})
+		},
+	})
+	RegisterEntropy(Entropy{
+		Name:  "tans",
+		Args:  Args{"block": 0, "log": 12, "states": 2},
+		Check: checkANSArgs,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.TANSCoder(ANS{Block: args["block"], Log: args["log"], States: args["states"]})
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.TANSDecoder(ANS{Block: args["block"], Log: args["log"], States: args["states"]})
+		},
+	})
+	RegisterEntropy(Entropy{
+		Name:  "huffman",
+		Args:  Args{"block": 0, "tables": 6, "group": 50, "length": 17},
+		Check: checkHuffmanArgs,
+		Coder: func(coder Coder16, args Args) Encoder {
+			return coder.HuffmanCoder(Huffman{Block: args["block"], Tables: args["tables"], Group: args["group"],
+				Length: args["length"]})
+		},
+		Decoder: func(decoder Coder16, args Args) Decoder {
+			return decoder.HuffmanDecoder(Huffman{Block: args["block"], Tables: args["tables"], Group: args["group"],
+				Length: args["length"]})
+		},
+	})
+}
+
+// ranged returns the entropy stage range-name, which codes the models of
+// entropy with Range or Range32 instead of the arithmetic coder
+func ranged(entropy Entropy) Entropy {
+	coder, decoder := entropy.Coder, entropy.Decoder
+	entropy.Name = "range-" + entropy.Name
+	entropy.Coder = func(c Coder16, args Args) Encoder {
+		switch model := coder(c, args).(type) {
+		case Model:
+			return Range(model)
+		case Model32:
+			return Range32(model)
+		default:
+			return model
+		}
+	}
+	entropy.Decoder = func(d Coder16, args Args) Decoder {
+		switch model := decoder(d, args).(type) {
+		case Model:
+			return Range(model)
+		case Model32:
+			return Range32(model)
+		default:
+			return model
+		}
+	}
+	return entropy
+}
+
+// budget returns the memory budget of a CDF tree from the arguments
+// nodes, policy (0 reset, 1 prune, 2 freeze) and hashed of its stage
+func budget(args Args) Budget {
+	return Budget{Nodes: args["nodes"], Policy: Policy(args["policy"]), Hashed: args["hashed"] == 1}
+}
+
+func checkBudget(args Args) error {
+	switch {
+	case args["policy"] > int(PolicyFreeze):
+		return fmt.Errorf("policy must be 0 (reset), 1 (prune) or 2 (freeze); got %d", args["policy"])
+	case args["hashed"] > 1:
+		return fmt.Errorf("hashed must be 0 or 1; got %d", args["hashed"])
+	case args["nodes"] > maxStateNodes:
+		return fmt.Errorf("nodes must be at most %d; got %d", maxStateNodes, args["nodes"])
+	}
+	return nil
+}
+
+// checkCDFArgs checks the depth argument of the cdf stages and their budget
+func checkCDFArgs(args Args) error {
+	if args["depth"] > CDFMaxDepth {
+		return fmt.Errorf("depth must be in 0..%d; got %d", CDFMaxDepth, args["depth"])
+	}
+	return checkBudget(args)
+}
+
+// checkIncrement returns the Check of a Fenwick stage, whose increment must
+// leave room for halving the counts of the largest alphabet under its scale
+func checkIncrement(max int) func(args Args) error {
+	return func(args Args) error {
+		if increment := args["increment"]; increment < 1 || increment > max {
+			return fmt.Errorf("increment must be in 1..%d; got %d", max, increment)
+		}
+		return nil
+	}
+}
+
+// checkANSArgs checks the block, log and states arguments of the rans and tans stages
+func checkANSArgs(args Args) error {
+	switch {
+	case args["log"] < ANS_MIN_LOG || args["log"] > ANS_MAX_LOG:
+		return fmt.Errorf("log must be in %d..%d; got %d", ANS_MIN_LOG, ANS_MAX_LOG, args["log"])
+	case args["states"] < 1 || args["states"] > ANS_MAX_STATES:
+		return fmt.Errorf("states must be in 1..%d; got %d", ANS_MAX_STATES, args["states"])
+	}
+	return nil
+}
+
+// checkPPMArgs checks the order and method arguments of the ppm stage and its budget
+func checkPPMArgs(args Args) error {
+	switch {
+	case args["order"] > PPM_MAX_ORDER:
+		return fmt.Errorf("order must be in 0..%d; got %d", PPM_MAX_ORDER, args["order"])
+	case args["method"] > int(PPMD):
+		return fmt.Errorf("method must be 0 (PPMC) or 1 (PPMD); got %d", args["method"])
+	}
+	return checkBudget(args)
+}
+
+// checkPPMStarArgs checks the window argument of the ppm-star stage and the arguments it shares with ppm
+func checkPPMStarArgs(args Args) error {
+	if args["window"] < PPM_STAR_MIN_WINDOW || args["window"] > PPM_STAR_MAX_WINDOW {
+		return fmt.Errorf("window must be in %d..%d; got %d", PPM_STAR_MIN_WINDOW, PPM_STAR_MAX_WINDOW, args["window"])
+	}
+	return checkPPMArgs(args)
+}
+
+// checkCTWArgs checks the depth argument of the ctw-bit stage
+func checkCTWArgs(args Args) error {
+	if args["depth"] > CTW_MAX_DEPTH {
+		return fmt.Errorf("depth must be in 0..%d; got %d", CTW_MAX_DEPTH, args["depth"])
+	}
+	return nil
+}
+
+// checkSSEArgs checks the order and rate arguments of the sse stages
+func checkSSEArgs(args Args) error {
+	switch {
+	case args["order"] > SSE_MAX_ORDER:
+		return fmt.Errorf("order must be in 0..%d; got %d", SSE_MAX_ORDER, args["order"])
+	case args["rate"] < 1 || args["rate"] > SSE_MAX_RATE:
+		return fmt.Errorf("rate must be in 1..%d; got %d", SSE_MAX_RATE, args["rate"])
+	}
+	return nil
+}
+
+// checkHuffmanArgs checks the tables, group and length arguments of the huffman stage
+func checkHuffmanArgs(args Args) error {
+	switch {
+	case args["tables"] < 1 || args["tables"] > HUFFMAN_MAX_TABLES:
+		return fmt.Errorf("tables must be in 1..%d; got %d", HUFFMAN_MAX_TABLES, args["tables"])
+	case args["group"] < 1:
+		return fmt.Errorf("group must be at least 1; got %d", args["group"])
+	case args["length"] < 1 || args["length"] > HUFFMAN_MAX_LENGTH:
+		return fmt.Errorf("length must be in 1..%d; got %d", HUFFMAN_MAX_LENGTH, args["length"])
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+const (
+	// IndexMagic starts and ends the seek index written after the last frame
+	// of a stream by a Writer with Options.Seekable
+	IndexMagic = "MRKX"
+	// IndexVersion is the version of the seek index written by this package
+	IndexVersion = 1
+	// indexTrailer is the size of the index length and magic at the end of a stream
+	indexTrailer = 4 + len(IndexMagic)
+)
+
+var (
+	// ErrIndex is returned when a stream has no valid seek index
+	ErrIndex = errors.New("compress: missing or invalid seek index")
+	// errIndex is returned by ReadFrameHeader when it finds a seek index instead of a frame
+	errIndex = errors.New("compress: seek index instead of frame")
+)
+
+// indexFrame is the position of a frame header and the first block of the frame
+type indexFrame struct {
+	position uint64
+	first    int
+}
+
+// indexBlock is the position of a compressed block and its uncompressed offset
+type indexBlock struct {
+	position, offset, length, compressed uint64
+}
+
+// index is the table of frames and blocks in the footer of a seekable stream
+type index struct {
+	frames []indexFrame
+	blocks []indexBlock
+	length uint64
+}
+
+// add records a frame whose header of size bytes was written at position
+func (x *index) add(position uint64, size int64, header *FrameHeader) {
+	x.frames = append(x.frames, indexFrame{position: position, first: len(x.blocks)})
+	position += uint64(size)
+	for _, block := range header.Blocks {
+		x.blocks = append(x.blocks, indexBlock{
+			position:   position,
+			offset:     x.length,
+			length:     block.Length,
+			compressed: block.Compressed,
+		})
+		position, x.length = position+block.Compressed, x.length+block.Length
+	}
+}
+
+// WriteTo writes the index: the magic, the version, the length of the body,
+// the body, the CRC32C of the body, the length of the whole index and the magic
+func (x *index) WriteTo(w io.Writer) (int64, error) {
+	var body []byte
+	var scratch [binary.MaxVarintLen64]byte
+	putUvarint := func(x uint64) {
+		n := binary.PutUvarint(scratch[:], x)
+		body = append(body, scratch[:n]...)
+	}
+	putUvarint(uint64(len(x.frames)))
+	for i, frame := range x.frames {
+		last := len(x.blocks)
+		if i+1 < len(x.frames) {
+			last = x.frames[i+1].first
+		}
+		putUvarint(frame.position)
+		putUvarint(uint64(last - frame.first))
+	}
+	for _, block := range x.blocks {
+		putUvarint(block.position)
+		putUvarint(block.length)
+		putUvarint(block.compressed)
+	}
+
+	buffer := append([]byte(IndexMagic), IndexVersion, 0)
+	n := binary.PutUvarint(scratch[:], uint64(len(body)))
+	buffer = append(buffer, scratch[:n]...)
+	buffer = append(buffer, body...)
+	var trailer [4 + indexTrailer]byte
+	binary.LittleEndian.PutUint32(trailer[:4], crc32.Checksum(body, castagnoli))
+	binary.LittleEndian.PutUint32(trailer[4:8], uint32(len(buffer)+len(trailer)))
+	copy(trailer[8:], IndexMagic)
+	buffer = append(buffer, trailer[:]...)
+	written, err := w.Write(buffer)
+	return int64(written), err
+}
+
+// skipIndex skips a seek index whose magic and version ReadFrameHeader has read
+func skipIndex(r io.Reader) error {
+	size, err := binary.ReadUvarint(&byteReader{Reader: r, count: 1})
+	if err == nil {
+		_, err = io.CopyN(ioutil.Discard, r, int64(size)+4+int64(indexTrailer))
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readIndex reads the seek index at the end of the size bytes of r
+func readIndex(r io.ReaderAt, size int64) (*index, error) {
+	var trailer [indexTrailer]byte
+	if size < int64(len(trailer)) {
+		return nil, ErrIndex
+	}
+	if _, err := r.ReadAt(trailer[:], size-int64(len(trailer))); err != nil {
+		return nil, err
+	}
+	length := int64(binary.LittleEndian.Uint32(trailer[:4]))
+	if string(trailer[4:]) != IndexMagic || length > size || length < int64(len(IndexMagic)+3+4+indexTrailer) {
+		return nil, ErrIndex
+	}
+	buffer := make([]byte, length)
+	if _, err := r.ReadAt(buffer, size-length); err != nil {
+		return nil, err
+	}
+	if string(buffer[:len(IndexMagic)]) != IndexMagic || buffer[len(IndexMagic)] != IndexVersion {
+		return nil, ErrIndex
+	}
+	buffer = buffer[len(IndexMagic)+2 : length-int64(indexTrailer)]
+	bodySize, n := binary.Uvarint(buffer)
+	if n <= 0 || bodySize != uint64(len(buffer)-n-4) {
+		return nil, ErrIndex
+	}
+	body := buffer[n : len(buffer)-4]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(buffer[len(buffer)-4:]) {
+		return nil, ErrIndex
+	}
+
+	in, bad := bytes.NewReader(body), false
+	getUvarint := func() uint64 {
+		x, err := binary.ReadUvarint(in)
+		bad = bad || err != nil
+		return x
+	}
+	x, end := &index{}, uint64(size-length)
+	frames := getUvarint()
+	if bad || frames > uint64(len(body)) {
+		return nil, ErrIndex
+	}
+	x.frames = make([]indexFrame, frames)
+	blocks := 0
+	for i := range x.frames {
+		x.frames[i] = indexFrame{position: getUvarint(), first: blocks}
+		count := getUvarint()
+		if count > uint64(len(body)) {
+			return nil, ErrIndex
+		}
+		blocks += int(count)
+	}
+	if bad || blocks > len(body) {
+		return nil, ErrIndex
+	}
+	x.blocks = make([]indexBlock, blocks)
+	for i := range x.blocks {
+		block := indexBlock{position: getUvarint(), offset: x.length, length: getUvarint(), compressed: getUvarint()}
+		if block.length == 0 || block.position > end || block.compressed > end-block.position {
+			return nil, ErrIndex
+		}
+		x.blocks[i], x.length = block, x.length+block.length
+	}
+	if bad || in.Len() != 0 {
+		return nil, ErrIndex
+	}
+	return x, nil
+}
+
+// SeekableReader decompresses a stream written by a Writer with
+// Options.Seekable, decoding only the blocks needed for each read.
+// It is safe to call ReadAt concurrently.
+type SeekableReader struct {
+	r      io.ReaderAt
+	size   int64
+	index  *index
+	offset int64
+
+	dictionaries []*Dictionary
+	mutex        sync.Mutex
+	headers      map[int]*FrameHeader
+	codecs       map[int]*Codec
+	cached       int
+	cache        []byte
+}
+
+// NewSeekableReader returns a SeekableReader of the size bytes of r,
+// reading the seek index at the end of r. Frames compressed with a
+// dictionary need it among dictionaries.
+func NewSeekableReader(r io.ReaderAt, size int64, dictionaries ...*Dictionary) (*SeekableReader, error) {
+	x, err := readIndex(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return &SeekableReader{
+		r:            r,
+		size:         size,
+		index:        x,
+		dictionaries: dictionaries,
+		headers:      make(map[int]*FrameHeader),
+		codecs:       make(map[int]*Codec),
+		cached:       -1,
+	}, nil
+}
+
+// Size returns the uncompressed size of the stream
+func (z *SeekableReader) Size() int64 {
+	return int64(z.index.length)
+}
+
+// block returns block i of the stream decompressed
+func (z *SeekableReader) block(i int) ([]byte, error) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	if z.cached == i {
+		return z.cache, nil
+	}
+
+	frames := z.index.frames
+	f := sort.Search(len(frames), func(j int) bool { return frames[j].first > i }) - 1
+	header, codec := z.headers[f], z.codecs[f]
+	if header == nil {
+		position := int64(frames[f].position)
+		if position >= z.size {
+			return nil, ErrIndex
+		}
+		h, err := ReadFrameHeader(bufio.NewReader(io.NewSectionReader(z.r, position, z.size-position)))
+		if err != nil {
+			return nil, err
+		}
+		if codec, err = h.codec(z.dictionaries); err != nil {
+			return nil, err
+		}
+		header, z.headers[f], z.codecs[f] = h, h, codec
+	}
+
+	j, block := i-frames[f].first, z.index.blocks[i]
+	if j >= len(header.Blocks) || header.Blocks[j].Length != block.length || header.Blocks[j].Compressed != block.compressed {
+		return nil, ErrIndex
+	}
+	compressed, output := make([]byte, block.compressed), make([]byte, block.length)
+	if _, err := z.r.ReadAt(compressed, int64(block.position)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err := decompressBlock(codec, header, j, compressed, output); err != nil {
+		return nil, err
+	}
+	z.cached, z.cache = i, output
+	return output, nil
+}
+
+// ReadAt reads len(p) uncompressed bytes starting at offset off
+func (z *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("compress: negative offset")
+	}
+	blocks, n := z.index.blocks, 0
+	i := sort.Search(len(blocks), func(j int) bool { return int64(blocks[j].offset+blocks[j].length) > off })
+	for ; n < len(p) && i < len(blocks); i++ {
+		block, err := z.block(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[off+int64(n)-int64(blocks[i].offset):])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads from the current offset
+func (z *SeekableReader) Read(p []byte) (int, error) {
+	if z.offset >= z.Size() {
+		return 0, io.EOF
+	}
+	n, err := z.ReadAt(p, z.offset)
+	z.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read in the uncompressed stream
+func (z *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.offset
+	case io.SeekEnd:
+		offset += z.Size()
+	default:
+		return 0, errors.New("compress: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("compress: negative position")
+	}
+	z.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import "fmt"
+
+const (
+	/* each context interpolates between 33 points of the stretched primary probability */
+	SSE_POINTS       = 33
+	SSE_CONTEXT_BITS = 16
+	SSE_MAX_ORDER    = 2
+	SSE_MAX_RATE     = 15
+	SSE_RATE         = 6
+)
+
+// sse is an adaptive probability map, or secondary symbol estimation, after
+// paq. The primary probability of a one is stretched and quantized, and the
+// probability is interpolated between the two nearest entries of a table for
+// the context, which is the bits of the symbol seen so far and the previous
+// order symbols. The nearer entry moves 1/2^rate of the way to each bit.
+type sse struct {
+	primary    BitPredictor
+	mask, bit  uint16
+	node, hash uint32
+	order      int
+	previous   [SSE_MAX_ORDER]uint16
+	table      []uint16
+	rate       uint
+	index      int
+}
+
+func checkSSE(order, rate int) {
+	if order < 0 || order > SSE_MAX_ORDER || rate < 1 || rate > SSE_MAX_RATE {
+		panic(fmt.Sprintf("compress: SSE order %d is not in 0..%d or rate %d is not in 1..%d",
+			order, SSE_MAX_ORDER, rate, SSE_MAX_RATE))
+	}
+}
+
+// NewSSEBitPredictor refines the probabilities of primary, which predicts the
+// bits of an alphabet of alphabit symbols, in the context of the previous
+// order symbols. The map adapts at a rate of 1/2^rate.
+func NewSSEBitPredictor(primary BitPredictor, alphabit uint16, order, rate int) BitPredictor {
+	checkSSE(order, rate)
+	highest := uint32(0)
+	for a := alphabit - 1; a > 0; a >>= 1 {
+		highest++
+	}
+	mask := uint16(1) << (highest - 1)
+	s := &sse{primary: primary, mask: mask, bit: mask, node: 1, order: order,
+		table: make([]uint16, SSE_POINTS<<SSE_CONTEXT_BITS), rate: uint(rate)}
+	for i := range s.table {
+		s.table[i] = uint16(squash(int32(i%SSE_POINTS-SSE_POINTS/2)*128) * 16)
+	}
+	return s
+}
+
+func (s *sse) Predict() (uint16, uint16) {
+	zero, scale := s.primary.Predict()
+	p := int32(scale-zero) * mixingScale / int32(scale)
+	if p < 1 {
+		p = 1
+	} else if p > mixingScale-1 {
+		p = mixingScale - 1
+	}
+
+	context := s.node
+	if s.order > 0 {
+		context = (s.hash ^ s.node*0x9e3779b1) >> (32 - SSE_CONTEXT_BITS)
+	}
+	stretched := stretchTable[p] + 2048
+	weight, base := stretched&127, int(context)*SSE_POINTS+int(stretched>>7)
+	refined := (int32(s.table[base])*(128-weight) + int32(s.table[base+1])*weight) >> 11
+	s.index = base + int(weight>>6)
+
+	/* the primary probability keeps a quarter of the weight, as in lpaq */
+	if p = (p + 3*refined) / 4; p < 1 {
+		p = 1
+	} else if p > mixingScale-1 {
+		p = mixingScale - 1
+	}
+	return uint16(mixingScale - p), mixingScale
+}
+
+func (s *sse) Update(b uint16) {
+	target, entry := int32(0), int32(s.table[s.index])
+	if b != 0 {
+		target = 1<<16 - 1
+	}
+	s.table[s.index] = uint16(entry + (target-entry)>>s.rate)
+	s.primary.Update(b)
+
+	s.node = s.node<<1 | uint32(b)
+	if b != 0 {
+		s.previous[0] |= s.bit
+	}
+	if s.bit >>= 1; s.bit > 0 {
+		return
+	}
+	s.hash = 0
+	for _, symbol := range s.previous[:s.order] {
+		s.hash = (s.hash + uint32(symbol) + 1) * 0x2f0b3c6d
+	}
+	copy(s.previous[1:], s.previous[:SSE_MAX_ORDER-1])
+	s.bit, s.node, s.previous[0] = s.mask, 1, 0
+}
+
+// SSEBitCoder codes the bits of each symbol with the probabilities of primary
+// refined by secondary estimation
+func (coder Coder16) SSEBitCoder(primary BitPredictor, order, rate int) Model {
+	return coder.BitCoder(NewSSEBitPredictor(primary, coder.Alphabit, order, rate))
+}
+
+func (decoder Coder16) SSEBitDecoder(primary BitPredictor, order, rate int) Model {
+	return decoder.BitDecoder(NewSSEBitPredictor(primary, decoder.Alphabit, order, rate))
+}
@@ -0,0 +1,508 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+const (
+	// StateMagic starts the serialized state of a model
+	StateMagic = "MRKM"
+	// StateVersion is the version of the model state written by this package.
+	// Version 2 added the memory budget of CDF trees; version 1 states are still read.
+	StateVersion = 2
+	// maxStateNodes bounds the node budget of a restored CDF tree
+	maxStateNodes = 1 << 24
+)
+
+// kinds of model state
+const (
+	stateCDF16 = iota + 1
+	stateCDF32
+	stateFrequencies16
+	stateFrequencies32
+)
+
+// ErrState is returned when a serialized model state is invalid or of another kind of model
+var ErrState = errors.New("compress: invalid model state")
+
+// stateWriter appends a model state as uvarints
+type stateWriter []byte
+
+func newStateWriter(kind byte) *stateWriter {
+	w := stateWriter(append([]byte(StateMagic), StateVersion, kind))
+	return &w
+}
+
+func (w *stateWriter) uvarint(x uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], x)
+	*w = append(*w, scratch[:n]...)
+}
+
+// stateReader reads a model state written by stateWriter; the first invalid
+// value sets err and every later value is zero
+type stateReader struct {
+	data    []byte
+	version byte
+	err     error
+}
+
+func newStateReader(data []byte, kind byte) *stateReader {
+	r := &stateReader{data: data}
+	if len(data) < len(StateMagic)+2 || string(data[:len(StateMagic)]) != StateMagic ||
+		data[len(StateMagic)] < 1 || data[len(StateMagic)] > StateVersion || data[len(StateMagic)+1] != kind {
+		r.err = ErrState
+		return r
+	}
+	r.version, r.data = data[len(StateMagic)], data[len(StateMagic)+2:]
+	return r
+}
+
+// uvarint reads a value no larger than max
+func (r *stateReader) uvarint(max uint64) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 || x > max {
+		r.err = ErrState
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
+
+// close returns the error of the reader, which is ErrState if data is left over
+func (r *stateReader) close() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.err = ErrState
+	}
+	return r.err
+}
+
+// MarshalBinary returns the context tree of c: the size of the alphabet, the
+// context, the budget and the clock, followed by the nodes. A CDF is written as
+// the differences between its entries. A tree is written in depth first order,
+// each node followed by when it was used and by its children in order of
+// symbol; a hashed tree is written as the root and the slots in use.
+func (c *CDF16) MarshalBinary() ([]byte, error) {
+	w := newStateWriter(stateCDF16)
+	w.uvarint(uint64(c.Size))
+	w.uvarint(uint64(len(c.Context)))
+	w.uvarint(uint64(c.First))
+	for _, s := range c.Context {
+		w.uvarint(uint64(s))
+	}
+	hashed := uint64(0)
+	if c.Table != nil {
+		hashed = 1
+	}
+	w.uvarint(uint64(c.Budget.Nodes))
+	w.uvarint(uint64(c.Budget.Policy))
+	w.uvarint(hashed)
+	w.uvarint(c.Clock)
+
+	cdf := func(model []uint16) {
+		for i := 1; i < len(model); i++ {
+			w.uvarint(uint64(model[i] - model[i-1]))
+		}
+	}
+	if c.Table != nil {
+		cdf(c.Root.Model)
+		used := 0
+		for _, node := range c.Table {
+			if node != nil {
+				used++
+			}
+		}
+		w.uvarint(uint64(used))
+		for i, node := range c.Table {
+			if node != nil {
+				w.uvarint(uint64(i))
+				w.uvarint(node.Check)
+				cdf(node.Model)
+			}
+		}
+		return *w, nil
+	}
+
+	var node func(n *Node16)
+	node = func(n *Node16) {
+		cdf(n.Model)
+		w.uvarint(n.Used)
+		symbols := make([]int, 0, len(n.Children))
+		for s := range n.Children {
+			symbols = append(symbols, int(s))
+		}
+		sort.Ints(symbols)
+		w.uvarint(uint64(len(symbols)))
+		for _, s := range symbols {
+			w.uvarint(uint64(s))
+			node(n.Children[uint16(s)])
+		}
+	}
+	node(c.Root)
+	return *w, nil
+}
+
+// UnmarshalBinary restores a context tree written by MarshalBinary, keeping Verify
+func (c *CDF16) UnmarshalBinary(data []byte) error {
+	r := newStateReader(data, stateCDF16)
+	size := int(r.uvarint(CDF16MaxSize))
+	depth := int(r.uvarint(uint64(len(data))))
+	first := int(r.uvarint(uint64(depth)))
+	if r.err == nil && (size < 2 || (depth > 0 && first >= depth)) {
+		r.err = ErrState
+	}
+	context := make([]uint16, depth)
+	for i := range context {
+		context[i] = uint16(r.uvarint(uint64(size - 1)))
+	}
+	budget, clock := Budget{}, uint64(0)
+	if r.version >= 2 {
+		budget.Nodes = int(r.uvarint(maxStateNodes))
+		budget.Policy = Policy(r.uvarint(uint64(PolicyFreeze)))
+		budget.Hashed, clock = r.uvarint(1) == 1, r.uvarint(1<<64-1)
+	}
+
+	cdf := func(model []uint16) {
+		for i := 1; i <= size && r.err == nil; i++ {
+			delta := r.uvarint(CDF16Scale)
+			if delta == 0 || uint64(model[i-1])+delta > CDF16Scale {
+				r.err = ErrState
+				break
+			}
+			model[i] = model[i-1] + uint16(delta)
+		}
+		if r.err == nil && model[size] != CDF16Scale {
+			r.err = ErrState
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	restored := CDF16{Size: size, Context: context, First: first, Budget: budget, Nodes: 1, Clock: clock}
+	restored.Root = &Node16{Model: make([]uint16, size+1), Children: make(map[uint16]*Node16)}
+	var slots []int
+	var nodes []*Node16
+	if budget.Hashed {
+		/* the table is allocated once the state is read, since a short state
+		may claim a large one; every used slot takes at least one byte */
+		cdf(restored.Root.Model)
+		used, previous := int(r.uvarint(uint64(budget.slots()))), -1
+		if used > len(r.data) {
+			r.err = ErrState
+		}
+		for i := 0; i < used && r.err == nil; i++ {
+			slot := int(r.uvarint(uint64(budget.slots() - 1)))
+			if slot <= previous {
+				r.err = ErrState
+				break
+			}
+			n := &Node16{Model: make([]uint16, size+1), Children: make(map[uint16]*Node16), Check: r.uvarint(1<<64 - 1)}
+			cdf(n.Model)
+			slots, nodes, previous = append(slots, slot), append(nodes, n), slot
+		}
+	} else {
+		var node func(n *Node16, level int)
+		node = func(n *Node16, level int) {
+			cdf(n.Model)
+			if r.version >= 2 {
+				n.Used = r.uvarint(clock)
+			}
+			max := uint64(size)
+			if level == depth {
+				max = 0
+			}
+			children, previous := int(r.uvarint(max)), -1
+			for i := 0; i < children && r.err == nil; i++ {
+				s := int(r.uvarint(uint64(size - 1)))
+				if s <= previous {
+					r.err = ErrState
+					break
+				}
+				child := &Node16{Model: make([]uint16, size+1), Children: make(map[uint16]*Node16)}
+				n.Children[uint16(s)], restored.Nodes, previous = child, restored.Nodes+1, s
+				node(child, level+1)
+			}
+		}
+		node(restored.Root, 0)
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	restored.table()
+	for i, slot := range slots {
+		restored.Table[slot] = nodes[i]
+	}
+	restored.Nodes += len(nodes)
+
+	restored.Verify = c.Verify
+	*c = restored
+	return nil
+}
+
+// MarshalBinary returns the context tree of c in the format of CDF32.MarshalBinary
+func (c *CDF32) MarshalBinary() ([]byte, error) {
+	w := newStateWriter(stateCDF32)
+	w.uvarint(uint64(c.Size))
+	w.uvarint(uint64(len(c.Context)))
+	w.uvarint(uint64(c.First))
+	for _, s := range c.Context {
+		w.uvarint(uint64(s))
+	}
+	hashed := uint64(0)
+	if c.Table != nil {
+		hashed = 1
+	}
+	w.uvarint(uint64(c.Budget.Nodes))
+	w.uvarint(uint64(c.Budget.Policy))
+	w.uvarint(hashed)
+	w.uvarint(c.Clock)
+
+	cdf := func(model []uint32) {
+		for i := 1; i < len(model); i++ {
+			w.uvarint(uint64(model[i] - model[i-1]))
+		}
+	}
+	if c.Table != nil {
+		cdf(c.Root.Model)
+		used := 0
+		for _, node := range c.Table {
+			if node != nil {
+				used++
+			}
+		}
+		w.uvarint(uint64(used))
+		for i, node := range c.Table {
+			if node != nil {
+				w.uvarint(uint64(i))
+				w.uvarint(node.Check)
+				cdf(node.Model)
+			}
+		}
+		return *w, nil
+	}
+
+	var node func(n *Node32)
+	node = func(n *Node32) {
+		cdf(n.Model)
+		w.uvarint(n.Used)
+		symbols := make([]int, 0, len(n.Children))
+		for s := range n.Children {
+			symbols = append(symbols, int(s))
+		}
+		sort.Ints(symbols)
+		w.uvarint(uint64(len(symbols)))
+		for _, s := range symbols {
+			w.uvarint(uint64(s))
+			node(n.Children[uint16(s)])
+		}
+	}
+	node(c.Root)
+	return *w, nil
+}
+
+// UnmarshalBinary restores a context tree written by MarshalBinary, keeping Verify
+func (c *CDF32) UnmarshalBinary(data []byte) error {
+	r := newStateReader(data, stateCDF32)
+	size := int(r.uvarint(CDF32MaxSize))
+	depth := int(r.uvarint(uint64(len(data))))
+	first := int(r.uvarint(uint64(depth)))
+	if r.err == nil && (size < 2 || (depth > 0 && first >= depth)) {
+		r.err = ErrState
+	}
+	context := make([]uint16, depth)
+	for i := range context {
+		context[i] = uint16(r.uvarint(uint64(size - 1)))
+	}
+	budget, clock := Budget{}, uint64(0)
+	if r.version >= 2 {
+		budget.Nodes = int(r.uvarint(maxStateNodes))
+		budget.Policy = Policy(r.uvarint(uint64(PolicyFreeze)))
+		budget.Hashed, clock = r.uvarint(1) == 1, r.uvarint(1<<64-1)
+	}
+
+	cdf := func(model []uint32) {
+		for i := 1; i <= size && r.err == nil; i++ {
+			delta := r.uvarint(CDF32Scale)
+			if delta == 0 || uint64(model[i-1])+delta > CDF32Scale {
+				r.err = ErrState
+				break
+			}
+			model[i] = model[i-1] + uint32(delta)
+		}
+		if r.err == nil && model[size] != CDF32Scale {
+			r.err = ErrState
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	restored := CDF32{Size: size, Context: context, First: first, Budget: budget, Nodes: 1, Clock: clock}
+	restored.Root = &Node32{Model: make([]uint32, size+1), Children: make(map[uint16]*Node32)}
+	var slots []int
+	var nodes []*Node32
+	if budget.Hashed {
+		/* the table is allocated once the state is read, since a short state
+		may claim a large one; every used slot takes at least one byte */
+		cdf(restored.Root.Model)
+		used, previous := int(r.uvarint(uint64(budget.slots()))), -1
+		if used > len(r.data) {
+			r.err = ErrState
+		}
+		for i := 0; i < used && r.err == nil; i++ {
+			slot := int(r.uvarint(uint64(budget.slots() - 1)))
+			if slot <= previous {
+				r.err = ErrState
+				break
+			}
+			n := &Node32{Model: make([]uint32, size+1), Children: make(map[uint16]*Node32), Check: r.uvarint(1<<64 - 1)}
+			cdf(n.Model)
+			slots, nodes, previous = append(slots, slot), append(nodes, n), slot
+		}
+	} else {
+		var node func(n *Node32, level int)
+		node = func(n *Node32, level int) {
+			cdf(n.Model)
+			if r.version >= 2 {
+				n.Used = r.uvarint(clock)
+			}
+			max := uint64(size)
+			if level == depth {
+				max = 0
+			}
+			children, previous := int(r.uvarint(max)), -1
+			for i := 0; i < children && r.err == nil; i++ {
+				s := int(r.uvarint(uint64(size - 1)))
+				if s <= previous {
+					r.err = ErrState
+					break
+				}
+				child := &Node32{Model: make([]uint32, size+1), Children: make(map[uint16]*Node32)}
+				n.Children[uint16(s)], restored.Nodes, previous = child, restored.Nodes+1, s
+				node(child, level+1)
+			}
+		}
+		node(restored.Root, 0)
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	restored.table()
+	for i, slot := range slots {
+		restored.Table[slot] = nodes[i]
+	}
+	restored.Nodes += len(nodes)
+
+	restored.Verify = c.Verify
+	*c = restored
+	return nil
+}
+
+// MarshalBinary returns the size of the alphabet, the number of tables, the
+// context and the counts of every table
+func (f *Frequencies16) MarshalBinary() ([]byte, error) {
+	w, alphabit := newStateWriter(stateFrequencies16), 0
+	if len(f.Tables) > 0 {
+		alphabit = len(f.Tables[0])
+	}
+	w.uvarint(uint64(alphabit))
+	w.uvarint(uint64(len(f.Tables)))
+	w.uvarint(uint64(f.Context))
+	for _, table := range f.Tables {
+		for _, count := range table {
+			w.uvarint(uint64(count))
+		}
+	}
+	return *w, nil
+}
+
+// UnmarshalBinary restores the tables written by MarshalBinary
+func (f *Frequencies16) UnmarshalBinary(data []byte) error {
+	r := newStateReader(data, stateFrequencies16)
+	alphabit := int(r.uvarint(MAX_SCALE16))
+	contexts := int(r.uvarint(uint64(alphabit)))
+	context := r.uvarint(uint64(contexts) - 1)
+	/* every count takes at least a byte, which bounds the tables allocated */
+	if r.err == nil && (alphabit < 1 || (contexts != 1 && contexts != alphabit) || len(r.data) < contexts*alphabit) {
+		r.err = ErrState
+	}
+	if r.err != nil {
+		return r.err
+	}
+	tables, scales := make([][]uint16, contexts), make([]uint16, contexts)
+	for i := range tables {
+		table, scale := make([]uint16, alphabit), uint64(0)
+		for j := range table {
+			count := r.uvarint(MAX_SCALE16)
+			if scale += count; count == 0 || scale > MAX_SCALE16 {
+				r.err = ErrState
+			}
+			table[j] = uint16(count)
+		}
+		tables[i], scales[i] = table, uint16(scale)
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	f.Tables, f.Scales, f.Context = tables, scales, uint16(context)
+	return nil
+}
+
+// MarshalBinary returns the tables in the format of Frequencies16.MarshalBinary
+func (f *Frequencies32) MarshalBinary() ([]byte, error) {
+	w, alphabit := newStateWriter(stateFrequencies32), 0
+	if len(f.Tables) > 0 {
+		alphabit = len(f.Tables[0])
+	}
+	w.uvarint(uint64(alphabit))
+	w.uvarint(uint64(len(f.Tables)))
+	w.uvarint(uint64(f.Context))
+	for _, table := range f.Tables {
+		for _, count := range table {
+			w.uvarint(uint64(count))
+		}
+	}
+	return *w, nil
+}
+
+// UnmarshalBinary restores the tables written by MarshalBinary
+func (f *Frequencies32) UnmarshalBinary(data []byte) error {
+	r := newStateReader(data, stateFrequencies32)
+	alphabit := int(r.uvarint(1<<16 - 1))
+	contexts := int(r.uvarint(uint64(alphabit)))
+	context := r.uvarint(uint64(contexts) - 1)
+	/* every count takes at least a byte, which bounds the tables allocated */
+	if r.err == nil && (alphabit < 1 || (contexts != 1 && contexts != alphabit) || len(r.data) < contexts*alphabit) {
+		r.err = ErrState
+	}
+	if r.err != nil {
+		return r.err
+	}
+	tables, scales := make([][]uint32, contexts), make([]uint32, contexts)
+	for i := range tables {
+		table, scale := make([]uint32, alphabit), uint64(0)
+		for j := range table {
+			count := r.uvarint(MAX_SCALE32)
+			if scale += count; count == 0 || scale > MAX_SCALE32 {
+				r.err = ErrState
+			}
+			table[j] = uint32(count)
+		}
+		tables[i], scales[i] = table, uint32(scale)
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	f.Tables, f.Scales, f.Context = tables, scales, uint16(context)
+	return nil
+}
@@ -0,0 +1,260 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"errors"
+	"io"
+	"runtime"
+)
+
+const (
+	// DefaultBlockSize is the block size used when Options.BlockSize is zero
+	DefaultBlockSize = 1 << 20
+	// MaxBlockSize is the largest block size; frame headers that describe larger blocks are rejected
+	MaxBlockSize = 1 << 30
+	/* a compressed block may be larger than its input, but not twice as large */
+	maxCompressed = 2 * MaxBlockSize
+)
+
+// ErrClosed is returned when writing to a closed Writer
+var ErrClosed = errors.New("compress: writer is closed")
+
+// Options configures a Writer
+type Options struct {
+	// Pipeline is the pipeline used to compress each block; Mark1Pipeline16 if zero
+	Pipeline Pipeline
+	// Spec is a pipeline spec such as bbwt|mtf-rle|cdf16(depth=2); it overrides Pipeline if set
+	Spec string
+	// BlockSize is the number of bytes compressed as one block; DefaultBlockSize if zero
+	// and at most MaxBlockSize
+	BlockSize int
+	// Workers is the number of blocks compressed in parallel; GOMAXPROCS if zero
+	Workers int
+	// Seekable makes Close write a seek index after the last frame for NewSeekableReader
+	Seekable bool
+	// Dictionary, if set, primes the models of every block; readers need the same dictionary
+	Dictionary *Dictionary
+}
+
+func (o *Options) pipeline() Pipeline {
+	if o == nil || o.Pipeline == 0 {
+		return Mark1Pipeline16
+	}
+	return o.Pipeline
+}
+
+// spec returns the pipeline and spec recorded in the header of frames written
+// with o, preferring a numbered pipeline if one matches Spec
+func (o *Options) spec() (Pipeline, string, error) {
+	if o == nil || o.Spec == "" {
+		pipeline := o.pipeline()
+		if !pipeline.valid() || pipeline == PipelineSpec {
+			return 0, "", ErrPipeline
+		}
+		return pipeline, pipeline.Spec(), nil
+	}
+
+	codec, err := ParseSpec(o.Spec)
+	if err != nil {
+		return 0, "", err
+	}
+	spec := codec.String()
+	for pipeline, s := range pipelineSpecs {
+		if s != "" && s == spec {
+			return Pipeline(pipeline), spec, nil
+		}
+	}
+	return PipelineSpec, spec, nil
+}
+
+func (o *Options) workers() int {
+	if o == nil || o.Workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.Workers
+}
+
+func (o *Options) blockSize() int {
+	if o == nil || o.BlockSize <= 0 {
+		return DefaultBlockSize
+	} else if o.BlockSize > MaxBlockSize {
+		return MaxBlockSize
+	}
+	return o.BlockSize
+}
+
+// Writer is an io.WriteCloser that compresses everything written to it into
+// a sequence of frames. Each frame holds up to Workers blocks, which are
+// compressed in parallel.
+type Writer struct {
+	w       io.Writer
+	options Options
+	size    int
+	buffer  []byte
+	frames  int
+	written uint64
+	index   *index
+	err     error
+	closed  bool
+}
+
+// NewWriter returns a Writer that compresses to w. A nil options uses the defaults.
+// The caller must Close the Writer to flush the last block.
+func NewWriter(w io.Writer, options *Options) *Writer {
+	z := &Writer{options: Options{Pipeline: options.pipeline(), BlockSize: options.blockSize(), Workers: options.workers()}}
+	if options != nil {
+		z.options.Spec, z.options.Seekable, z.options.Dictionary = options.Spec, options.Seekable, options.Dictionary
+	}
+	z.size = z.options.BlockSize * z.options.Workers
+	z.Reset(w)
+	return z
+}
+
+// Reset discards the state of z and makes it write to w, keeping the options
+func (z *Writer) Reset(w io.Writer) {
+	z.w, z.buffer, z.frames, z.written, z.closed, z.err = w, z.buffer[:0], 0, 0, false, nil
+	z.index = nil
+	if z.options.Seekable {
+		z.index = &index{}
+	}
+	if _, _, err := z.options.spec(); err != nil {
+		z.err = err
+	}
+}
+
+// Write buffers p and compresses a frame whenever Workers blocks fill up
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, ErrClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := z.size - len(z.buffer)
+		if n > len(p) {
+			n = len(p)
+		}
+		z.buffer, p, written = append(z.buffer, p[:n]...), p[n:], written+n
+		if len(z.buffer) == z.size {
+			if err := z.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush compresses any buffered data as a frame and writes it out
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buffer) == 0 {
+		return nil
+	}
+	if z.err = z.frame(z.buffer); z.err != nil {
+		return z.err
+	}
+	z.buffer = z.buffer[:0]
+	return nil
+}
+
+// frame compresses input as one frame and records it in the seek index
+func (z *Writer) frame(input []byte) error {
+	header, blocks, err := compressFrame(input, &z.options)
+	if err != nil {
+		return err
+	}
+	size, err := writeFrame(z.w, header, blocks)
+	if err != nil {
+		return err
+	}
+	if z.index != nil {
+		z.index.add(z.written, size, header)
+	}
+	z.written += uint64(size)
+	for _, block := range header.Blocks {
+		z.written += block.Compressed
+	}
+	z.frames++
+	return nil
+}
+
+// Close flushes the last block and writes the seek index if the stream is
+// seekable. It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if err := z.Flush(); err != nil {
+		return err
+	}
+	z.closed = true
+	if z.frames == 0 {
+		/* an empty stream is still one frame so that readers can validate it */
+		if z.err = z.frame(nil); z.err != nil {
+			return z.err
+		}
+	}
+	if z.index != nil {
+		_, z.err = z.index.WriteTo(z.w)
+	}
+	return z.err
+}
+
+// Reader is an io.Reader that decompresses a sequence of frames
+type Reader struct {
+	r            io.Reader
+	dictionaries []*Dictionary
+	buffer       []byte
+	err          error
+}
+
+// NewReader returns a Reader that decompresses r. The first frame is read
+// immediately so that an invalid stream is reported here. Frames compressed
+// with a dictionary need it among dictionaries.
+func NewReader(r io.Reader, dictionaries ...*Dictionary) (*Reader, error) {
+	z := &Reader{dictionaries: dictionaries}
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Reset discards the state of z and makes it read from r, keeping the dictionaries
+func (z *Reader) Reset(r io.Reader) error {
+	z.r = r
+	z.buffer, z.err = Mark1DecompressFrame(r, z.dictionaries...)
+	if z.err == io.EOF {
+		z.err = io.ErrUnexpectedEOF
+	}
+	return z.err
+}
+
+// Read decompresses frames as needed to fill p
+func (z *Reader) Read(p []byte) (int, error) {
+	for len(z.buffer) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.buffer, z.err = Mark1DecompressFrame(z.r, z.dictionaries...)
+	}
+
+	n := copy(p, z.buffer)
+	z.buffer = z.buffer[n:]
+	return n, nil
+}
+
+// Close does not close the underlying reader
+func (z *Reader) Close() error {
+	if z.err == io.EOF {
+		return nil
+	}
+	return z.err
+}
@@ -0,0 +1,254 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compress
+
+// SuffixArray returns the starting positions of the suffixes of input in
+// sorted order. It runs in linear time using induced sorting (SA-IS).
+func SuffixArray(input []byte) []int32 {
+	length := len(input)
+	text, sa := make([]int32, length+1), make([]int32, length+1)
+	for i, v := range input {
+		text[i] = int32(v) + 1
+	}
+	sais(text, sa, 257)
+	return sa[1:]
+}
+
+func buckets(s, bucket []int32, end bool) {
+	for i := range bucket {
+		bucket[i] = 0
+	}
+	for _, c := range s {
+		bucket[c]++
+	}
+	sum := int32(0)
+	for i, count := range bucket {
+		sum += count
+		if end {
+			bucket[i] = sum
+		} else {
+			bucket[i] = sum - count
+		}
+	}
+}
+
+func induce(s, sa, bucket []int32, stype []bool) {
+	buckets(s, bucket, false)
+	for i := range sa {
+		if j := sa[i] - 1; j >= 0 && !stype[j] {
+			sa[bucket[s[j]]], bucket[s[j]] = j, bucket[s[j]]+1
+		}
+	}
+	buckets(s, bucket, true)
+	for i := len(sa) - 1; i >= 0; i-- {
+		if j := sa[i] - 1; j >= 0 && stype[j] {
+			bucket[s[j]]--
+			sa[bucket[s[j]]] = j
+		}
+	}
+}
+
+// sais computes the suffix array of s into sa. The symbols of s must be less
+// than k and s must end with a unique smallest symbol.
+// Nong, Zhang and Chan, "Two Efficient Algorithms for Linear Time Suffix Array Construction"
+func sais(s, sa []int32, k int) {
+	length := len(s)
+	if length == 1 {
+		sa[0] = 0
+		return
+	}
+
+	/* classify the suffixes as S (smaller than the next suffix) or L */
+	stype := make([]bool, length)
+	stype[length-1] = true
+	for i := length - 3; i >= 0; i-- {
+		stype[i] = s[i] < s[i+1] || (s[i] == s[i+1] && stype[i+1])
+	}
+	lms := func(i int32) bool {
+		return i > 0 && stype[i] && !stype[i-1]
+	}
+
+	/* sort the LMS substrings */
+	bucket := make([]int32, k)
+	buckets(s, bucket, true)
+	for i := range sa {
+		sa[i] = -1
+	}
+	for i := int32(1); i < int32(length); i++ {
+		if lms(i) {
+			bucket[s[i]]--
+			sa[bucket[s[i]]] = i
+		}
+	}
+	induce(s, sa, bucket, stype)
+
+	/* name the LMS substrings */
+	n := 0
+	for _, p := range sa {
+		if lms(p) {
+			sa[n], n = p, n+1
+		}
+	}
+	for i := n; i < length; i++ {
+		sa[i] = -1
+	}
+	name, previous := int32(0), int32(-1)
+	for i := 0; i < n; i++ {
+		p, different := sa[i], false
+		for d := int32(0); d < int32(length); d++ {
+			if previous == -1 || s[p+d] != s[previous+d] || stype[p+d] != stype[previous+d] {
+				different = true
+				break
+			} else if d > 0 && (lms(p+d) || lms(previous+d)) {
+				break
+			}
+		}
+		if different {
+			name, previous = name+1, p
+		}
+		sa[n+int(p/2)] = name - 1
+	}
+	for i, j := length-1, length-1; i >= n; i-- {
+		if sa[i] >= 0 {
+			sa[j], j = sa[i], j-1
+		}
+	}
+
+	/* sort the LMS suffixes, recursing if the names are not unique */
+	sa1, s1 := sa[:n], sa[length-n:]
+	if int(name) < n {
+		sais(s1, sa1, int(name))
+	} else {
+		for i, c := range s1 {
+			sa1[c] = int32(i)
+		}
+	}
+
+	/* induce the order of all of the suffixes from the LMS suffixes */
+	buckets(s, bucket, true)
+	for i, j := int32(1), 0; i < int32(length); i++ {
+		if lms(i) {
+			s1[j], j = i, j+1
+		}
+	}
+	for i := range sa1 {
+		sa1[i] = s1[sa1[i]]
+	}
+	for i := n; i < length; i++ {
+		sa[i] = -1
+	}
+	for i := n - 1; i >= 0; i-- {
+		j := sa[i]
+		sa[i] = -1
+		bucket[s[j]]--
+		sa[bucket[s[j]]] = j
+	}
+	induce(s, sa, bucket, stype)
+}
+
+// burrowsWheeler writes the Burrows-Wheeler transform of input to output,
+// treating the end of input as a sentinel larger than every byte. The sentinel
+// itself is not written; its position is returned.
+func burrowsWheeler(input, output []byte) (sentinel int) {
+	length := len(input)
+	text, sa := make([]int32, length+2), make([]int32, length+2)
+	for i, v := range input {
+		text[i] = int32(v) + 1
+	}
+	text[length] = 257
+	sais(text, sa, 258)
+
+	written := 0
+	for _, p := range sa[1:] {
+		if p == 0 {
+			sentinel = written
+			continue
+		}
+		output[written], written = input[p-1], written+1
+	}
+	return
+}
+
+// bijectiveBurrowsWheeler writes the bijective Burrows-Wheeler transform of
+// input to output. The suffix array is turned into the order of the rotations
+// of the Lyndon words of input by moving each Lyndon word into place.
+// https://github.com/flanglet/kanzi-go
+func bijectiveBurrowsWheeler(input, output []byte) {
+	length := int32(len(input))
+	if length == 0 {
+		return
+	}
+	text, sa := make([]int32, length+1), make([]int32, length+1)
+	for i, v := range input {
+		text[i] = int32(v) + 1
+	}
+	sais(text, sa, 257)
+	sa, isa := sa[1:], text[:length]
+	for i, p := range sa {
+		isa[p] = int32(i)
+	}
+
+	/* each new minimum of the inverse suffix array starts a Lyndon word */
+	minimum, start := isa[0], int32(0)
+	for i := int32(1); i < length && minimum > 0; i++ {
+		if isa[i] >= minimum {
+			continue
+		}
+
+		rank := moveLyndonWord(sa, isa, input, start, i-start, minimum)
+		for j := i - 1; j > start; j-- {
+			test := isa[j]
+			first := test
+			for test < length-1 {
+				next := sa[test+1]
+				if j > next || input[j] != input[next] || (next+1 < length && rank < isa[next+1]) {
+					break
+				}
+				sa[test], isa[next], test = next, test, test+1
+			}
+			sa[test], isa[j], rank = j, test, test
+			if first == test {
+				break
+			}
+		}
+		minimum, start = isa[i], i
+	}
+
+	minimum = length
+	for i := int32(0); i < length; i++ {
+		if isa[i] >= minimum {
+			output[isa[i]] = input[i-1]
+			continue
+		}
+		if minimum < length {
+			output[minimum] = input[i-1]
+		}
+		minimum = isa[i]
+	}
+	output[0] = input[length-1]
+}
+
+func moveLyndonWord(sa, isa []int32, input []byte, start, size, rank int32) int32 {
+	length, end := int32(len(input)), start+size
+	for rank+1 < length {
+		first := sa[rank+1]
+		if first <= end {
+			break
+		}
+		next, k := first, int32(0)
+		for k < size && next < length && input[start+k] == input[next] {
+			k, next = k+1, next+1
+		}
+		if k == size && (next == length || rank < isa[next]) {
+			break
+		}
+		if k < size && next < length && input[start+k] < input[next] {
+			break
+		}
+		sa[rank], isa[first], rank = first, rank, rank+1
+	}
+	sa[rank], isa[start] = start, rank
+	return rank
+}
@@ -4,6 +4,8 @@
 
 package compress
 
+import "context"
+
 //import "fmt"
 //import "time"
 
@@ -287,119 +289,189 @@ func burrowsWheelerDecoder(input []byte, key int) (output []byte) {
 }
 
 func BurrowsWheelerCoder(input <-chan []byte) (Coder8, <-chan int) {
+	return BurrowsWheelerCoderContext(nil, input)
+}
+
+// BurrowsWheelerCoderContext is BurrowsWheelerCoder with a context that tears
+// down the pipeline started from it
+func BurrowsWheelerCoderContext(ctx context.Context, input <-chan []byte) (Coder8, <-chan int) {
 	output, sentinels := make(chan []byte), make(chan int, BUFFER_COUNT)
 
 	var buffer []uint8
-	encode := func(block []byte) {
+	encode := func(block []byte) int {
 		if cap(buffer) < len(block) {
 			buffer = make([]uint8, len(block))
 		} else {
 			buffer = buffer[:len(block)]
 		}
 		copy(buffer, block)
+		return burrowsWheeler(buffer, block)
+	}
 
-		//start := time.Now()
-		tree := BuildSuffixTree(buffer)
-		//fmt.Printf("build: %v\n", time.Now().Sub(start).String())
-
-		//start = time.Now()
-		edges := tree.edges
-		histogram, end, written := [257]uint{}, len(buffer), 0
-
-		for _, v := range buffer {
-			histogram[v]++
+	go func() {
+		defer close(output)
+		cancel := done(ctx)
+
+		for block, ok := receive(input, cancel); ok; block, ok = receive(input, cancel) {
+			select {
+			case sentinels <- encode(block):
+			case <-cancel:
+				return
+			}
+			select {
+			case output <- block:
+			case <-cancel:
+				return
+			}
 		}
-		histogram[256]++
+	}()
 
-		var walk func(node, depth uint)
-		walk = func(node, depth uint) {
-			node <<= SYMBOL_SIZE
-			for c := uint(0); c <= 256; c++ {
-				if histogram[c] == 0 {
-					continue
-				}
+	return Coder8{Alphabit:256, Input:output, Context:ctx}, sentinels
+}
 
-				edge, has := edges[node|c]
-				if !has {
-					continue
-				}
+func BurrowsWheelerDecoder(input <-chan []byte, sentinels <-chan int) Coder8 {
+	return BurrowsWheelerDecoderContext(nil, input, sentinels)
+}
 
-				depth := depth + uint(edge.last_index) - uint(edge.first_index) + 1
-				if int(edge.last_index) < end {
-					walk(uint(edge.end_node), depth)
-				} else if depth > uint(end) {
-					sentinels <- written
-				} else {
-					block[written], written = buffer[uint(end)-depth], written + 1
-				}
+// BurrowsWheelerDecoderContext is BurrowsWheelerDecoder with a context that
+// makes Decode return the context error once it is done
+func BurrowsWheelerDecoderContext(ctx context.Context, input <-chan []byte, sentinels <-chan int) Coder8 {
+	buffer, i, next, cancel := []byte(nil), 0, blocks(ctx, input), done(ctx)
+	add := func(symbol uint8) bool {
+		for len(buffer) == 0 {
+			block, ok := next()
+			if !ok {
+				return true
 			}
+			buffer = block
 		}
 
-		walk(0, 0)
-		//fmt.Printf("walk: %v\n", time.Now().Sub(start).String())
+		buffer[i], i = symbol, i + 1
+		if i == len(buffer) {
+			var key int
+			select {
+			case key = <-sentinels:
+			case <-cancel:
+				raise(ctx.Err())
+			}
+			if key < 0 || key > len(buffer) {
+				corrupt("burrows wheeler decoder", uint32(key))
+			}
+			inverseBurrowsWheeler(buffer, key)
+			block, ok := next()
+			if !ok {
+				return true
+			}
+			buffer, i = block, 0
+		}
+		return false
 	}
 
-	go func() {
-		for block := range input {
-			encode(block)
-			output <- block
+        return Coder8{Alphabit:256, Output:add, Context:ctx}
+
+}
+
+// inverseBurrowsWheeler undoes burrowsWheeler in place given the sentinel position key
+func inverseBurrowsWheeler(buffer []byte, key int) {
+	length, sum := len(buffer), 0
+	minor, major, input := make([]int, length+1), [257]int{}, make([]byte, length+1)
+
+	copy(input, buffer[:key])
+	copy(input[key+1:], buffer[key:])
+	for k, v := range input {
+		v := int(v)
+		if k == key {
+			v = 256
 		}
+		minor[k] = major[v]
+		major[v]++
+	}
 
-		close(output)
-	}()
+	for k, v := range major {
+		major[k] = sum
+		sum += v
+	}
 
-	return Coder8{Alphabit:256, Input:output}, sentinels
+	key = length
+	for c := length - 1; c >= 0; c-- {
+		buffer[c], key = input[key], major[input[key]]+minor[key]
+	}
 }
 
-func BurrowsWheelerDecoder(input <-chan []byte, sentinels <-chan int) Coder8 {
-	inverse := func(buffer []byte, key int) {
-		length, sum := len(buffer), 0
-		minor, major, input := make([]int, length + 1), [257]int{}, make([]byte, length + 1)
-
-		copy(input, buffer[:key])
-		copy(input[key + 1:], buffer[key:])
-		for k, v := range input {
-			v := int(v)
-			if k == key {
-				v = 256
+// BurrowsWheelerInlineCoder is BurrowsWheelerCoder with the sentinel position
+// of each nonempty block written as 4 big endian bytes in front of the block, so that
+// the output can be decoded by BurrowsWheelerInlineDecoder without a side channel
+func BurrowsWheelerInlineCoder(input <-chan []byte) Coder8 {
+	return BurrowsWheelerInlineCoderContext(nil, input)
+}
+
+// BurrowsWheelerInlineCoderContext is BurrowsWheelerInlineCoder with a context
+// that tears down the pipeline started from it
+func BurrowsWheelerInlineCoderContext(ctx context.Context, input <-chan []byte) Coder8 {
+	output := make(chan []byte)
+
+	go func() {
+		defer close(output)
+		cancel := done(ctx)
+
+		for block, ok := receive(input, cancel); ok; block, ok = receive(input, cancel) {
+			/* the decoder skips empty blocks without reading a sentinel position */
+			if len(block) == 0 {
+				continue
+			}
+			buffer := make([]byte, len(block)+4)
+			sentinel := uint32(burrowsWheeler(block, buffer[4:]))
+			buffer[0], buffer[1], buffer[2], buffer[3] =
+				byte(sentinel>>24), byte(sentinel>>16), byte(sentinel>>8), byte(sentinel)
+			select {
+			case output <- buffer:
+			case <-cancel:
+				return
 			}
-			minor[k] = major[v]
-			major[v]++
 		}
+	}()
 
-		for k, v := range major {
-			major[k] = sum
-			sum += v
-		}
+	return Coder8{Alphabit: 256, Input: output, Context: ctx}
+}
 
-		key = length
-		for c := length - 1; c >= 0; c-- {
-			buffer[c], key = input[key], major[input[key]]+minor[key]
-		}
-	}
+// BurrowsWheelerInlineDecoder decodes the output of BurrowsWheelerInlineCoder,
+// reading the sentinel position of each block from the symbols in front of it
+func BurrowsWheelerInlineDecoder(input <-chan []byte) Coder8 {
+	return BurrowsWheelerInlineDecoderContext(nil, input)
+}
 
-	buffer, i := []byte(nil), 0
+// BurrowsWheelerInlineDecoderContext is BurrowsWheelerInlineDecoder with a
+// context that makes Decode return the context error once it is done
+func BurrowsWheelerInlineDecoderContext(ctx context.Context, input <-chan []byte) Coder8 {
+	buffer, i, key, header, next := []byte(nil), 0, uint32(0), 0, blocks(ctx, input)
 	add := func(symbol uint8) bool {
-		if len(buffer) == 0 {
-			next, ok := <-input
+		for len(buffer) == 0 {
+			block, ok := next()
 			if !ok {
 				return true
 			}
-			buffer = next
+			buffer = block
 		}
 
-		buffer[i], i = symbol, i + 1
+		if header < 4 {
+			key, header = key<<8|uint32(symbol), header+1
+			if header == 4 && key > uint32(len(buffer)) {
+				corrupt("burrows wheeler inline decoder", key)
+			}
+			return false
+		}
+
+		buffer[i], i = symbol, i+1
 		if i == len(buffer) {
-			inverse(buffer, <-sentinels)
-			next, ok := <-input
+			inverseBurrowsWheeler(buffer, int(key))
+			block, ok := next()
 			if !ok {
 				return true
 			}
-			buffer, i = next, 0
+			buffer, i, key, header = block, 0, 0, 0
 		}
 		return false
 	}
 
-        return Coder8{Alphabit:256, Output:add}
-
+	return Coder8{Alphabit: 256, Output: add, Context: ctx}
 }
@@ -14,11 +14,13 @@ func Mark1Compress16(input []byte, output io.Writer) {
 	BijectiveBurrowsWheelerCoder(channel).MoveToFrontRunLengthCoder().AdaptiveCoder().Code(output)
 }
 
-func Mark1Decompress16(input io.Reader, output []byte) {
+// Mark1Decompress16 decompresses input written by Mark1Compress16 into output,
+// which must have the length of the original data
+func Mark1Decompress16(input io.Reader, output []byte) error {
 	channel := make(chan []byte, 1)
 	channel <- output
 	close(channel)
-	BijectiveBurrowsWheelerDecoder(channel).MoveToFrontRunLengthDecoder().AdaptiveDecoder().Decode(input)
+	return BijectiveBurrowsWheelerDecoder(channel).MoveToFrontRunLengthDecoder().AdaptiveDecoder().Decode(input)
 }
 
 func Mark1Compress1(input []byte, output io.Writer) {
@@ -29,9 +31,29 @@ func Mark1Compress1(input []byte, output io.Writer) {
 	BijectiveBurrowsWheelerCoder(channel).MoveToFrontCoder().FilteredAdaptiveBitCoder().Code(output)
 }
 
-func Mark1Decompress1(input io.Reader, output []byte) {
+// Mark1Decompress1 decompresses input written by Mark1Compress1 into output,
+// which must have the length of the original data
+func Mark1Decompress1(input io.Reader, output []byte) error {
 	channel := make(chan []byte, 1)
 	channel <- output
 	close(channel)
-	BijectiveBurrowsWheelerDecoder(channel).MoveToFrontDecoder().FilteredAdaptiveBitDecoder().Decode(input)
+	return BijectiveBurrowsWheelerDecoder(channel).MoveToFrontDecoder().FilteredAdaptiveBitDecoder().Decode(input)
+}
+
+// BurrowsWheelerCompress16 is Mark1Compress16 with the Burrows-Wheeler transform
+// in place of the bijective one; the primary index is stored inline
+func BurrowsWheelerCompress16(input []byte, output io.Writer) {
+	channel := make(chan []byte, 1)
+	channel <- input
+	close(channel)
+	BurrowsWheelerInlineCoder(channel).MoveToFrontRunLengthCoder().AdaptiveCoder().Code(output)
+}
+
+// BurrowsWheelerDecompress16 decompresses input written by BurrowsWheelerCompress16
+// into output, which must have the length of the original data
+func BurrowsWheelerDecompress16(input io.Reader, output []byte) error {
+	channel := make(chan []byte, 1)
+	channel <- output
+	close(channel)
+	return BurrowsWheelerInlineDecoder(channel).MoveToFrontRunLengthDecoder().AdaptiveDecoder().Decode(input)
 }